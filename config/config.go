@@ -5,8 +5,12 @@ import (
 )
 
 type Config struct {
+	ConfigFile      string
 	Refresh         time.Duration
+	Reconcile       time.Duration
+	Subscribe       bool
 	Zk              string
+	MesosStateFile  string
 	LogLevel        string
 	MesosIpOrder    string
 	Healthcheck     bool
@@ -19,17 +23,81 @@ type Config struct {
 	TaskTag         []string
 	Separator       string
 
+	// Refresh intervals without a successful sync before
+	// the health check fails
+	HealthcheckMaxRefreshes int
+
+	// Zookeeper digest authentication and timeouts
+	ZkAuth           string
+	ZkConnectTimeout time.Duration
+	ZkSessionTimeout time.Duration
+
+	// Address of the local Mesos agent in agent mode
+	MesosAgent string
+
+	// Masters to find the leader through, in place of Zookeeper
+	MesosMasters string
+
+	// Timeout and retries of the state fetches
+	MesosTimeout time.Duration
+	MesosRetries int
+
+	// TLS and credentials of the Mesos HTTP API
+	MesosSSL       bool
+	MesosSSLCaCert string
+	MesosSSLCert   string
+	MesosSSLKey    string
+	MesosAuth      string
+	MesosAuthFile  string
+
+	// Registry backend
+	Registry   string
+	DryRun     bool
+	PlanFormat string
+
+	// Leader election among replicas
+	HA     bool
+	HAPath string
+
+	// Deregistration grace of the services whose task is gone
+	HeartbeatsBeforeRemove int
+	DeregisterGrace        time.Duration
+
+	// Mass-deregistration breaker
+	DeregisterMaxPercent    int
+	DeregisterMaxCount      int
+	DeregisterConfirmCycles int
+	DeregisterReleaseAPI    bool
+
 	// Mesos service name and tags
 	ServiceName      string
 	ServiceTags      string
 	ServiceIdPrefix  string
 	ServicePortLabel string
+
+	// Service metadata
+	ServiceMetaPrefix string
+	InstanceName      string
+
+	// Check options used when the task labels don't set them
+	CheckTimeout         string
+	CheckHTTPMethod      string
+	CheckHTTPHeader      []string
+	CheckTLSSkipVerify   bool
+	CheckGRPCUseTLS      bool
+	CheckDeregisterAfter string
+
+	// TTL of the check following the Mesos health of the tasks
+	MesosHealthTTL time.Duration
 }
 
 func DefaultConfig() *Config {
 	return &Config{
 		Refresh:          time.Minute,
+		Reconcile:        5 * time.Minute,
+		Subscribe:        false,
 		Zk:               "zk://127.0.0.1:2181/mesos",
+		LogLevel:         "WARN",
 		MesosIpOrder:     "netinfo,mesos,host",
 		Healthcheck:      false,
 		HealthcheckIp:    "127.0.0.1",
@@ -40,9 +108,29 @@ func DefaultConfig() *Config {
 		FwBlackList:      []string{},
 		TaskTag:          []string{},
 		Separator:        "",
+		Registry:         "consul",
+		DryRun:           false,
+		PlanFormat:       "text",
 		ServiceName:      "mesos",
 		ServiceTags:      "",
 		ServiceIdPrefix:  "mesos-consul",
 		ServicePortLabel: "",
+
+		HealthcheckMaxRefreshes: 3,
+		DeregisterConfirmCycles: 3,
+		HeartbeatsBeforeRemove:  1,
+		HAPath:                  "/mesos-consul",
+		ZkConnectTimeout:        10 * time.Second,
+		ZkSessionTimeout:        10 * time.Second,
+		MesosTimeout:            30 * time.Second,
+		MesosRetries:            2,
+		ServiceMetaPrefix:       "consul_meta_",
 	}
 }
+
+// UsesZk returns true when Zookeeper is needed: to find the leading
+// master, unless the masters, an agent or state files are given, and
+// for the election
+func (c *Config) UsesZk() bool {
+	return c.HA || (c.MesosMasters == "" && c.MesosAgent == "" && c.MesosStateFile == "")
+}
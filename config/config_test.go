@@ -0,0 +1,122 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	if err := DefaultConfig().Validate(); err != nil {
+		t.Fatalf("default configuration is invalid: %s", err)
+	}
+
+	c := DefaultConfig()
+	c.LogLevel = "WARN"
+	c.MesosIpOrder = "netinfo,dns"
+	c.TaskWhiteList = []string{"ok", "bad("}
+	c.FwBlackList = []string{"[bad"}
+	c.TaskTag = []string{"web:public", "nocolon", ":tag"}
+	c.Registry = "consul,zookeeper"
+	c.CheckTimeout = "5"
+	c.CheckHTTPHeader = []string{"X-Ok: 1", "noheader"}
+	c.MesosSSL = true
+	c.MesosSSLKey = "key.pem"
+
+	err := c.Validate()
+	if err == nil {
+		t.Fatal("invalid configuration passed validation")
+	}
+
+	errs, ok := err.(Errors)
+	if !ok {
+		t.Fatalf("Validate() returned %T, want Errors", err)
+	}
+	if len(errs) != 9 {
+		t.Errorf("got %d errors, want 9:\n%s", len(errs), err)
+	}
+	for _, want := range []string{"mesos-ip-order", "whitelist", "fw-blacklist", "nocolon", "':tag'", "zookeeper", "check-timeout", "noheader", "mesos-ssl-cert"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error doesn't mention %s:\n%s", want, err)
+		}
+	}
+}
+
+func TestValidateWithoutZk(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		set  func(c *Config)
+		ok   bool
+	}{
+		{"zookeeper", func(c *Config) {}, false},
+		{"masters", func(c *Config) { c.MesosMasters = "http://10.0.0.10:5050" }, true},
+		{"agent", func(c *Config) { c.MesosAgent = "10.0.0.1:5051" }, true},
+		{"state file", func(c *Config) { c.MesosStateFile = "state.json" }, true},
+		{"masters with ha", func(c *Config) { c.MesosMasters = "http://10.0.0.10:5050"; c.HA = true }, false},
+	} {
+		c := DefaultConfig()
+		c.Zk = ""
+		tt.set(c)
+
+		if err := c.Validate(); (err == nil) != tt.ok {
+			t.Errorf("%s without --zk: Validate() => %v", tt.name, err)
+		}
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mesos-consul-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	want := [][2]string{
+		{"dry-run", "true"},
+		{"heartbeats-before-remove", "3"},
+		{"refresh", "30s"},
+		{"task-tag", "web:public"},
+		{"task-tag", "db:private,backup"},
+	}
+
+	for name, content := range map[string]string{
+		"config.hcl": `
+refresh = "30s"
+dry-run = true
+heartbeats-before-remove = 3
+task-tag = ["web:public", "db:private,backup"]
+`,
+		"config.json": `{
+  "refresh": "30s",
+  "dry-run": true,
+  "heartbeats-before-remove": 3,
+  "task-tag": ["web:public", "db:private,backup"]
+}`,
+	} {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		var got [][2]string
+		err := LoadFile(path, func(name, value string) error {
+			got = append(got, [2]string{name, value})
+			return nil
+		})
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %v, want %v", name, got, want)
+		}
+	}
+
+	path := filepath.Join(dir, "nested.hcl")
+	ioutil.WriteFile(path, []byte(`consul { port = 8500 }`), 0644)
+	if err := LoadFile(path, func(string, string) error { return nil }); err == nil {
+		t.Error("nested blocks should be rejected")
+	}
+}
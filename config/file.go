@@ -0,0 +1,81 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+
+	"github.com/hashicorp/hcl"
+)
+
+// LoadFile reads an HCL or JSON configuration file. Every key is the name
+// of a command line option, and its value is passed to set as if it was
+// given on the command line. Options that can be given several times
+// take a list, and set is called once per element.
+func LoadFile(path string, set func(name, value string) error) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var options map[string]interface{}
+	if err := hcl.Decode(&options, string(b)); err != nil {
+		return fmt.Errorf("%s: %s", path, err)
+	}
+
+	names := make([]string, 0, len(options))
+	for name := range options {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var errs Errors
+	for _, name := range names {
+		values, err := optionValues(options[name])
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %s: %s", path, name, err))
+			continue
+		}
+
+		for _, v := range values {
+			if err := set(name, v); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %s: %s", path, name, err))
+				break
+			}
+		}
+	}
+
+	return errs.ErrorOrNil()
+}
+
+// optionValues converts a decoded value to option strings
+func optionValues(v interface{}) ([]string, error) {
+	switch t := v.(type) {
+	case string:
+		return []string{t}, nil
+	case bool:
+		return []string{strconv.FormatBool(t)}, nil
+	case int:
+		return []string{strconv.Itoa(t)}, nil
+	case int64:
+		return []string{strconv.FormatInt(t, 10)}, nil
+	case float64:
+		return []string{strconv.FormatFloat(t, 'f', -1, 64)}, nil
+	case []interface{}:
+		var values []string
+		for _, e := range t {
+			if _, ok := e.([]interface{}); ok {
+				return nil, fmt.Errorf("nested lists are not supported")
+			}
+			ev, err := optionValues(e)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, ev...)
+		}
+		return values, nil
+	}
+
+	return nil, fmt.Errorf("unsupported value %v", v)
+}
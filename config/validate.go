@@ -0,0 +1,250 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Errors collects every problem found in a configuration
+type Errors []error
+
+func (e Errors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = "  * " + err.Error()
+	}
+
+	return fmt.Sprintf("%d configuration error(s):\n%s", len(e), strings.Join(msgs, "\n"))
+}
+
+// ErrorOrNil returns nil if no error was collected
+func (e Errors) ErrorOrNil() error {
+	if len(e) == 0 {
+		return nil
+	}
+
+	return e
+}
+
+// Validate checks every option and reports all the problems at once
+func (c *Config) Validate() error {
+	var errs Errors
+
+	if _, err := log.ParseLevel(strings.ToLower(c.LogLevel)); err != nil {
+		errs = append(errs, fmt.Errorf("log-level: invalid level '%s'", c.LogLevel))
+	}
+
+	if c.Refresh <= 0 {
+		errs = append(errs, fmt.Errorf("refresh: must be positive, got %s", c.Refresh))
+	}
+	if c.Reconcile < 0 {
+		errs = append(errs, fmt.Errorf("reconcile: must not be negative, got %s", c.Reconcile))
+	}
+
+	if c.UsesZk() {
+		if !strings.HasPrefix(c.Zk, "zk://") {
+			errs = append(errs, fmt.Errorf("zk: '%s' must start with zk://", c.Zk))
+		}
+		if c.ZkAuth != "" && !strings.Contains(c.ZkAuth, ":") {
+			errs = append(errs, fmt.Errorf("zk-auth: must be <user>:<password>"))
+		}
+		if c.ZkConnectTimeout <= 0 {
+			errs = append(errs, fmt.Errorf("zk-connect-timeout: must be positive, got %s", c.ZkConnectTimeout))
+		}
+		if c.ZkSessionTimeout <= 0 {
+			errs = append(errs, fmt.Errorf("zk-session-timeout: must be positive, got %s", c.ZkSessionTimeout))
+		}
+	}
+
+	for _, src := range strings.Split(c.MesosIpOrder, ",") {
+		switch src {
+		case "netinfo", "host", "docker", "mesos":
+		default:
+			errs = append(errs, fmt.Errorf("mesos-ip-order: invalid source '%s', must be one of netinfo, mesos, docker, host", src))
+		}
+	}
+
+	if c.Healthcheck {
+		if p, err := strconv.Atoi(c.HealthcheckPort); err != nil || p < 1 || p > 65535 {
+			errs = append(errs, fmt.Errorf("healthcheck-port: invalid port '%s'", c.HealthcheckPort))
+		}
+		if c.HealthcheckMaxRefreshes < 1 {
+			errs = append(errs, fmt.Errorf("healthcheck-max-refreshes: must be at least 1, got %d", c.HealthcheckMaxRefreshes))
+		}
+	}
+
+	for _, l := range []struct {
+		name     string
+		patterns []string
+	}{
+		{"whitelist", c.TaskWhiteList},
+		{"blacklist", c.TaskBlackList},
+		{"fw-whitelist", c.FwWhiteList},
+		{"fw-blacklist", c.FwBlackList},
+	} {
+		for _, p := range l.patterns {
+			if _, err := regexp.Compile(p); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %s", l.name, err))
+			}
+		}
+	}
+
+	for _, tt := range c.TaskTag {
+		parts := strings.Split(tt, ":")
+		if len(parts) != 2 {
+			errs = append(errs, fmt.Errorf("task-tag: '%s' must include 1 colon separator", tt))
+			continue
+		}
+		if parts[0] == "" || parts[1] == "" {
+			errs = append(errs, fmt.Errorf("task-tag: '%s' must be <pattern>:<tag>[,<tag>...]", tt))
+		}
+	}
+
+	if c.ServiceIdPrefix == "" {
+		errs = append(errs, fmt.Errorf("service-id-prefix: must not be empty"))
+	}
+
+	for _, name := range strings.Split(c.Registry, ",") {
+		name = strings.TrimSpace(name)
+		switch {
+		case name == "consul", name == "etcd", name == "file-sd":
+		case strings.HasPrefix(name, "consul://"):
+			if _, err := url.Parse(name); err != nil {
+				errs = append(errs, fmt.Errorf("registry: %s", err))
+			}
+		default:
+			errs = append(errs, fmt.Errorf("registry: invalid backend '%s'", name))
+		}
+	}
+
+	if c.HeartbeatsBeforeRemove < 0 {
+		errs = append(errs, fmt.Errorf("heartbeats-before-remove: must not be negative, got %d", c.HeartbeatsBeforeRemove))
+	}
+	if c.DeregisterGrace < 0 {
+		errs = append(errs, fmt.Errorf("deregister-grace: must not be negative, got %s", c.DeregisterGrace))
+	}
+
+	if c.DeregisterMaxPercent < 0 || c.DeregisterMaxPercent > 100 {
+		errs = append(errs, fmt.Errorf("deregister-max-percent: must be between 0 and 100, got %d", c.DeregisterMaxPercent))
+	}
+	if c.DeregisterMaxCount < 0 {
+		errs = append(errs, fmt.Errorf("deregister-max-count: must not be negative, got %d", c.DeregisterMaxCount))
+	}
+	if c.DeregisterConfirmCycles < 0 {
+		errs = append(errs, fmt.Errorf("deregister-confirm-cycles: must not be negative, got %d", c.DeregisterConfirmCycles))
+	}
+	if c.DeregisterConfirmCycles == 0 && (c.DeregisterMaxPercent > 0 || c.DeregisterMaxCount > 0) && !c.DeregisterReleaseAPI {
+		errs = append(errs, fmt.Errorf("deregister-confirm-cycles: 0 waits for an operator, which needs --deregister-release-api"))
+	}
+
+	if c.HA && !strings.HasPrefix(c.HAPath, "/") {
+		errs = append(errs, fmt.Errorf("ha-path: '%s' must be an absolute path", c.HAPath))
+	}
+
+	if c.MesosAgent != "" {
+		for _, o := range []struct {
+			set  bool
+			name string
+		}{
+			{c.Subscribe, "mesos-subscribe"},
+			{c.HA, "ha"},
+			{c.MesosStateFile != "", "mesos-state-file"},
+		} {
+			if o.set {
+				errs = append(errs, fmt.Errorf("mesos-agent: can't be used with --%s", o.name))
+			}
+		}
+	}
+
+	if c.MesosTimeout < 0 {
+		errs = append(errs, fmt.Errorf("mesos-timeout: must not be negative, got %s", c.MesosTimeout))
+	}
+	if c.MesosRetries < 0 {
+		errs = append(errs, fmt.Errorf("mesos-retries: must not be negative, got %d", c.MesosRetries))
+	}
+
+	if c.MesosMasters != "" {
+		for _, m := range strings.Split(c.MesosMasters, ",") {
+			m = strings.TrimSpace(m)
+			u, err := url.Parse(m)
+			switch {
+			case err != nil:
+				errs = append(errs, fmt.Errorf("mesos-masters: %s", err))
+			case (u.Scheme != "http" && u.Scheme != "https") || u.Host == "":
+				errs = append(errs, fmt.Errorf("mesos-masters: '%s' must be http://<host>:<port> or https://<host>:<port>", m))
+			case u.Scheme == "https" && !c.MesosSSL:
+				errs = append(errs, fmt.Errorf("mesos-masters: '%s' requires --mesos-ssl", m))
+			}
+		}
+		for _, o := range []struct {
+			set  bool
+			name string
+		}{
+			{c.MesosAgent != "", "mesos-agent"},
+			{c.MesosStateFile != "", "mesos-state-file"},
+		} {
+			if o.set {
+				errs = append(errs, fmt.Errorf("mesos-masters: can't be used with --%s", o.name))
+			}
+		}
+	}
+
+	if (c.MesosSSLCert == "") != (c.MesosSSLKey == "") {
+		errs = append(errs, fmt.Errorf("mesos-ssl-cert: --mesos-ssl-cert and --mesos-ssl-key must be given together"))
+	}
+	if !c.MesosSSL {
+		for _, o := range []struct {
+			set  bool
+			name string
+		}{
+			{c.MesosSSLCaCert != "", "mesos-ssl-cacert"},
+			{c.MesosSSLCert != "", "mesos-ssl-cert"},
+		} {
+			if o.set {
+				errs = append(errs, fmt.Errorf("%s: requires --mesos-ssl", o.name))
+			}
+		}
+	}
+	if c.MesosAuth != "" && c.MesosAuthFile != "" {
+		errs = append(errs, fmt.Errorf("mesos-auth: can't be used with --mesos-auth-file"))
+	}
+
+	for _, d := range []struct {
+		name  string
+		value string
+	}{
+		{"check-timeout", c.CheckTimeout},
+		{"check-deregister-critical-service-after", c.CheckDeregisterAfter},
+	} {
+		if d.value == "" {
+			continue
+		}
+		if _, err := time.ParseDuration(d.value); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %s", d.name, err))
+		}
+	}
+	for _, h := range c.CheckHTTPHeader {
+		parts := strings.SplitN(h, ":", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			errs = append(errs, fmt.Errorf("check-http-header: '%s' must be <name>: <value>", h))
+		}
+	}
+
+	if c.MesosHealthTTL < 0 {
+		errs = append(errs, fmt.Errorf("mesos-health-ttl: must not be negative, got %s", c.MesosHealthTTL))
+	} else if c.MesosHealthTTL > 0 && c.MesosHealthTTL <= c.Refresh {
+		errs = append(errs, fmt.Errorf("mesos-health-ttl: %s must be longer than refresh %s, or the checks expire between two refreshes", c.MesosHealthTTL, c.Refresh))
+	}
+
+	if c.PlanFormat != "text" && c.PlanFormat != "json" {
+		errs = append(errs, fmt.Errorf("plan-format: must be text or json, got '%s'", c.PlanFormat))
+	}
+
+	return errs.ErrorOrNil()
+}
@@ -6,43 +6,39 @@ import (
 
 	"github.com/mantl/mesos-consul/registry"
 
-	consulapi "github.com/hashicorp/consul/api"
 	log "github.com/sirupsen/logrus"
 )
 
-type cacheEntry struct {
-	service         *consulapi.AgentServiceRegistration
-	agent           string
-	validityCounter int
-}
-
-func newCacheEntry(service *consulapi.AgentServiceRegistration, agent string) *cacheEntry {
-	return &cacheEntry{
-		agent:           agent,
-		service:         service,
-		validityCounter: 0,
-	}
-}
-
-// Service cache
-var serviceCache map[string]*cacheEntry
-var cacheEntryValidityThreshold int = 1
-
-// CacheCreate()
+// cachedService()
+//   Copy the service for the cache, with the agent it is registered on
 //
-func (c *Consul) CacheCreate() bool {
-	if serviceCache == nil {
-		serviceCache = make(map[string]*cacheEntry)
-		return true
+func cachedService(service *registry.Service, agent string) *registry.Service {
+	s := *service
+	s.Agent = agent
+
+	// The caller may reuse the slices
+	if len(service.Tags) > 0 {
+		s.Tags = make([]string, len(service.Tags))
+		copy(s.Tags, service.Tags)
+	}
+	if len(service.Meta) > 0 {
+		s.Meta = make(map[string]string, len(service.Meta))
+		for k, v := range service.Meta {
+			s.Meta[k] = v
+		}
 	}
 
-	return false
+	return &s
 }
 
 // Initialize the service cache
 //
 func (c *Consul) CacheLoad(host, serviceIdPrefix string) error {
-	client := c.client(host).Catalog()
+	agent := c.client(c.agentAddress(host))
+	if agent == nil {
+		return fmt.Errorf("no Consul agent to load the cache from")
+	}
+	client := agent.Catalog()
 
 	serviceList, _, err := client.Services(nil)
 	if err != nil {
@@ -60,13 +56,15 @@ func (c *Consul) CacheLoad(host, serviceIdPrefix string) error {
 		for _, s := range catalogServices {
 			if strings.HasPrefix(s.ServiceID, searchStr) {
 				log.Debugf("Found '%s' with ID '%s'", s.ServiceName, s.ServiceID)
-				serviceCache[s.ServiceID] = newCacheEntry(&consulapi.AgentServiceRegistration{
+				c.CacheAdd(&registry.Service{
 					ID:      s.ServiceID,
 					Name:    s.ServiceName,
 					Port:    s.ServicePort,
 					Address: s.ServiceAddress,
 					Tags:    s.ServiceTags,
-				}, s.Address)
+					Agent:   c.agentAddress(s.Address),
+					Meta:    s.ServiceMeta,
+				}, "")
 			}
 		}
 	}
@@ -74,53 +72,48 @@ func (c *Consul) CacheLoad(host, serviceIdPrefix string) error {
 	return nil
 }
 
-// CacheLookup()
+// CacheLoadAgent()
+//   Load the cache from the services of a single Consul agent, so
+//   that services registered through other agents are left alone
 //
-func (c *Consul) CacheLookup(id string) *registry.Service {
-	if _, ok := serviceCache[id]; ok {
-		s := serviceCache[id].service
-
-		return &registry.Service{
-			ID:      s.ID,
-			Name:    s.Name,
-			Port:    s.Port,
-			Address: s.Address,
-			Tags:    s.Tags,
-		}
+func (c *Consul) CacheLoadAgent(host, serviceIdPrefix string) error {
+	services, err := c.List([]string{host}, serviceIdPrefix)
+	if err != nil {
+		return err
+	}
+
+	for _, s := range services {
+		log.Debugf("Found '%s' with ID '%s'", s.Name, s.ID)
+		c.CacheAdd(s, "")
 	}
 
 	return nil
 }
 
-// CacheDelete()
+// CacheAdopt()
+//   Take the registration loaded from the catalog as registered with
+//   the fingerprint of service, when the catalog shows the same
+//   service. The catalog doesn't hold the checks, and the instance
+//   that registered it is another one.
 //
-func (c *Consul) CacheDelete(id string) {
-	if _, ok := serviceCache[id]; ok {
-		delete(serviceCache, id)
+func (c *Consul) CacheAdopt(service *registry.Service) {
+	e := c.Cached(service.ID)
+	if e == nil || e.Fingerprint != "" {
+		return
 	}
-}
 
-// CacheMark()
-//   Mark the service ID as valid
-//
-func (c *Consul) CacheMark(id string) {
-	if _, ok := serviceCache[id]; ok {
-		serviceCache[id].validityCounter = 0
+	s := *service
+	if _, ok := service.Meta[registry.InstanceMeta]; ok {
+		s.Meta = make(map[string]string, len(service.Meta))
+		for k, v := range service.Meta {
+			s.Meta[k] = v
+		}
+		s.Meta[registry.InstanceMeta] = e.Service.Meta[registry.InstanceMeta]
 	}
-}
-
-// CacheProcessDeregister()
-//   Calculate the validity of the entry
-//
-func (c *Consul) CacheProcessDeregister(id string) {
-	if _, ok := serviceCache[id]; ok {
-		serviceCache[id].validityCounter++
+	if !catalogEq(e.Service, &s) {
+		return
 	}
-}
 
-func (c *Consul) CacheIsValid(id string) bool {
-	if _, ok := serviceCache[id]; ok {
-		return serviceCache[id].validityCounter < cacheEntryValidityThreshold
-	}
-	return false
+	e.Service = cachedService(service, c.agentAddress(service.Agent))
+	e.Fingerprint = service.Fingerprint()
 }
@@ -8,22 +8,24 @@ import (
 )
 
 type consulConfig struct {
-	enabled                bool
-	auth                   auth
-	port                   string
-	sslEnabled             bool
-	sslVerify              bool
-	sslCert                string
-	sslCaCert              string
-	token                  string
-	timeout                int
-	dryRun                 bool
-	heartbeatsBeforeRemove int
+	enabled    bool
+	address    string
+	auth       auth
+	port       string
+	sslEnabled bool
+	sslVerify  bool
+	sslCert    string
+	sslCaCert  string
+	token      string
+	timeout    int
+	dryRun     bool
 }
 
 var config consulConfig
 
 func AddCmdFlags(f *flag.FlagSet) {
+	config = consulConfig{}
+
 	f.BoolVar(&config.enabled, "consul", false, "")
 	f.StringVar(&config.port, "consul-port", "8500", "")
 	f.Var((*authVar)(&config.auth), "consul-auth", "")
@@ -33,15 +35,13 @@ func AddCmdFlags(f *flag.FlagSet) {
 	f.StringVar(&config.sslCaCert, "consul-ssl-cacert", "", "")
 	f.StringVar(&config.token, "consul-token", "", "")
 	f.IntVar(&config.timeout, "consul-timeout", 0, "")
-	f.BoolVar(&config.dryRun, "dry-run", false, "")
-	f.IntVar(&config.heartbeatsBeforeRemove, "heartbeats-before-remove", 1, "")
 }
 
 func Help() string {
 	helpText := `
 Consul Options:
 
-  --consul			Use Consul backend
+  --consul			Use Consul backend (deprecated, same as --registry=consul)
   --consul-port			Consul agent API port
 				(default: 8500)
   --consul-auth			The basic authentication username (and optional password),
@@ -62,10 +62,6 @@ Consul Options:
 				(default: not set)
   --consul-timeout		Set a timeout (in seconds) on requests to Consul
 				(default: 0)
-  --dry-run			Do not register anything in Consul, just log what would have been done.
-  --heartbeats-before-remove	Number of times that registration needs to fail
-				before removing task from Consul
-				(default: 1)
 
 `
 
@@ -5,9 +5,14 @@ import (
 	"crypto/x509"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
+	"net/url"
+	"sort"
+	"sync"
 	"time"
 
+	"github.com/mantl/mesos-consul/metrics"
 	"github.com/mantl/mesos-consul/registry"
 
 	consulapi "github.com/hashicorp/consul/api"
@@ -15,16 +20,75 @@ import (
 )
 
 type Consul struct {
+	registry.Cache
+
 	agents map[string]*consulapi.Client
 	config consulConfig
+
+	// Agent calls made and failed in the current cycle, and
+	// the outcome of the last complete cycle
+	cycleCalls  int
+	cycleFailed int
+	cycleLock   sync.Mutex
+	cycleErr    error
 }
 
 //
-func New() *Consul {
-	return &Consul{
+func New(dryRun bool) *Consul {
+	c := &Consul{
+		Cache:  registry.NewCache("consul"),
 		agents: make(map[string]*consulapi.Client),
 		config: config,
 	}
+	c.config.dryRun = dryRun
+
+	return c
+}
+
+// NewFromURL()
+//   Create a Consul registry for another cluster, described as
+//   consul://[address][:port][?token=<token>]
+//   Without an address, services are registered on the agent of
+//   the Mesos host like the default registry. With an address, every
+//   service is registered through the agent at that address.
+//
+func NewFromURL(spec string, dryRun bool) (*Consul, error) {
+	u, err := url.Parse(spec)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "consul" {
+		return nil, fmt.Errorf("invalid Consul URL '%s'", spec)
+	}
+
+	c := New(dryRun)
+
+	host, port, err := net.SplitHostPort(u.Host)
+	if err != nil {
+		// No port
+		host = u.Host
+	}
+	c.config.address = host
+	if port != "" {
+		c.config.port = port
+	}
+
+	if token := u.Query().Get("token"); token != "" {
+		c.config.token = token
+	}
+
+	return c, nil
+}
+
+// agentAddress()
+//   Return the address of the Consul agent to use for a Mesos host
+//
+func (c *Consul) agentAddress(host string) string {
+	if c.config.address != "" {
+		return c.config.address
+	}
+
+	return host
 }
 
 // client()
@@ -124,76 +188,289 @@ func (c *Consul) newAgent(address string) *consulapi.Client {
 }
 
 func (c *Consul) Register(service *registry.Service) {
-	if _, ok := serviceCache[service.ID]; ok {
-		log.Debugf("Service found. Not registering: %s", service.ID)
-		c.CacheMark(service.ID)
-		return
+	fingerprint := service.Fingerprint()
+	agent := c.agentAddress(service.Agent)
+
+	if e := c.Cached(service.ID); e != nil {
+		// Entries loaded from the catalog don't hold checks. Adopt
+		// the registration instead of registering it again, unless
+		// it has checks, which may have changed meanwhile.
+		adopt := e.Fingerprint == "" && !hasChecks(service) && catalogEq(e.Service, service)
+
+		if e.Fingerprint == fingerprint || adopt {
+			err := c.updateHealth(agent, service)
+			if err == nil {
+				log.Debugf("Service found. Not registering: %s", service.ID)
+				if adopt {
+					e.Service = cachedService(service, agent)
+					e.Fingerprint = fingerprint
+				}
+				e.Service.Grace = service.Grace
+				metrics.ServicesSkipped.WithLabelValues("consul", agent, service.Framework).Inc()
+				c.CacheMark(service.ID)
+				return
+			}
+
+			log.Infof("Unable to update the checks of %s: %s. Re-registering", service.ID, err)
+		} else {
+			log.Infof("Service %s changed. Re-registering", service.ID)
+		}
 	}
 
 	if c.config.dryRun {
 		log.Info("Dry run, not registering ", service.ID)
+		c.CacheMark(service.ID)
 		return
 	}
 
-	if _, ok := c.agents[service.Agent]; !ok {
-		// Agent connection not saved. Connect.
-		c.agents[service.Agent] = c.newAgent(service.Agent)
+	client := c.client(agent)
+	if client == nil {
+		metrics.RegistrationErrors.WithLabelValues("consul", agent).Inc()
+		c.cycleCall(false)
+		return
 	}
 
 	log.Info("Registering ", service.ID)
 
+	s := c.registration(service)
+
+	err := client.Agent().ServiceRegister(s)
+	if err != nil {
+		log.Warnf("Unable to register %s: %s", s.ID, err.Error())
+		metrics.RegistrationErrors.WithLabelValues("consul", agent).Inc()
+		c.cycleCall(false)
+		return
+	}
+	c.cycleCall(true)
+
+	// Registering only sets the status of the TTL checks, push
+	// their output too
+	if err := c.updateHealth(agent, service); err != nil {
+		log.Warnf("Unable to update the checks of %s: %s", s.ID, err)
+	}
+
+	metrics.ServicesRegistered.WithLabelValues("consul", agent, service.Framework).Inc()
+	c.CacheAdd(cachedService(service, agent), fingerprint)
+}
+
+// updateHealth()
+//   Push the status of the TTL checks of the service that carry one
+//
+func (c *Consul) updateHealth(agent string, service *registry.Service) error {
+	if c.config.dryRun {
+		return nil
+	}
+
+	checks := service.Checks
+	if service.Check != nil {
+		checks = append([]*registry.Check{service.Check}, checks...)
+	}
+
+	for _, check := range checks {
+		if check.ID == "" || check.Status == "" {
+			continue
+		}
+
+		client := c.client(agent)
+		if client == nil {
+			return fmt.Errorf("no Consul agent")
+		}
+
+		err := client.Agent().UpdateTTL(check.ID, check.Output, check.Status)
+		c.cycleCall(err == nil)
+		if err != nil {
+			metrics.RegistrationErrors.WithLabelValues("consul", agent).Inc()
+			return err
+		}
+	}
+
+	return nil
+}
+
+// registration()
+//   Convert a registry.Service to a Consul service registration
+//
+func (c *Consul) registration(service *registry.Service) *consulapi.AgentServiceRegistration {
 	s := &consulapi.AgentServiceRegistration{
 		ID:      service.ID,
 		Name:    service.Name,
 		Port:    service.Port,
 		Address: service.Address,
-		Check: &consulapi.AgentServiceCheck{
-			TTL:      service.Check.TTL,
-			Script:   service.Check.Script,
-			TCP:      service.Check.TCP,
-			HTTP:     service.Check.HTTP,
-			Interval: service.Check.Interval,
-		},
+	}
+
+	if service.Check != nil {
+		s.Check = agentCheck(service.Check)
+	}
+	for _, check := range service.Checks {
+		s.Checks = append(s.Checks, agentCheck(check))
 	}
 
 	if len(service.Tags) > 0 {
-		s.Tags = service.Tags
+		// Copy the tags, the caller may reuse the slice
+		s.Tags = make([]string, len(service.Tags))
+		copy(s.Tags, service.Tags)
 	}
 
-	err := c.agents[service.Agent].Agent().ServiceRegister(s)
-	if err != nil {
-		log.Warnf("Unable to register %s: %s", s.ID, err.Error())
-		return
+	if len(service.Meta) > 0 {
+		s.Meta = make(map[string]string, len(service.Meta))
+		for k, v := range service.Meta {
+			s.Meta[k] = v
+		}
+	}
+
+	return s
+}
+
+// agentCheck()
+//   Convert a registry.Check to a Consul service check
+//
+func agentCheck(check *registry.Check) *consulapi.AgentServiceCheck {
+	return &consulapi.AgentServiceCheck{
+		TTL:      check.TTL,
+		Script:   check.Script,
+		TCP:      check.TCP,
+		HTTP:     check.HTTP,
+		Interval: check.Interval,
+		Timeout:  check.Timeout,
+		Name:     check.Name,
+		Notes:    check.Notes,
+		CheckID:  check.ID,
+		Status:   check.Status,
+
+		Method:            check.Method,
+		Header:            check.Header,
+		TLSSkipVerify:     check.TLSSkipVerify,
+		GRPC:              check.GRPC,
+		GRPCUseTLS:        check.GRPCUseTLS,
+		DockerContainerID: check.DockerContainerID,
+
+		DeregisterCriticalServiceAfter: check.DeregisterCriticalServiceAfter,
+	}
+}
+
+// hasChecks()
+//   Return true if the service carries a check of any type
+//
+func hasChecks(service *registry.Service) bool {
+	checks := service.Checks
+	if service.Check != nil {
+		checks = append([]*registry.Check{service.Check}, checks...)
+	}
+
+	for _, c := range checks {
+		if c.HasType() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// catalogEq()
+//   Compare the fields of a registration that are visible in the catalog
+//
+func catalogEq(s *registry.Service, service *registry.Service) bool {
+	if s.Name != service.Name || s.Port != service.Port || s.Address != service.Address {
+		return false
+	}
+
+	a := make([]string, len(s.Tags))
+	copy(a, s.Tags)
+	sort.Strings(a)
+
+	b := make([]string, len(service.Tags))
+	copy(b, service.Tags)
+	sort.Strings(b)
+
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	if len(s.Meta) != len(service.Meta) {
+		return false
+	}
+	for k, v := range service.Meta {
+		if mv, ok := s.Meta[k]; !ok || mv != v {
+			return false
+		}
 	}
 
-	serviceCache[s.ID] = newCacheEntry(s, service.Agent)
-	c.CacheMark(s.ID)
+	return true
 }
 
 // Deregister()
 //   Deregister services that no longer exist
 //
 func (c *Consul) Deregister() {
-	for s, b := range serviceCache {
-		if c.CacheIsValid(s) {
-			c.CacheProcessDeregister(s)
-		} else {
-			log.Infof("Deregistering %s", s)
-			err := c.deregister(b.agent, b.service)
-			if err != nil {
-				log.Info("Deregistration error ", err)
-			} else {
-				delete(serviceCache, s)
-			}
+	c.CacheSweep(func(e *registry.CachedService) bool {
+		s := e.Service
+		if c.config.dryRun {
+			log.Info("Dry run, not deregistering ", s.ID)
+			return true
 		}
+
+		log.Infof("Deregistering %s", s.ID)
+		err := c.deregister(s.Agent, s.ID)
+		c.cycleCall(err == nil)
+		if err != nil {
+			log.Info("Deregistration error ", err)
+			metrics.RegistrationErrors.WithLabelValues("consul", s.Agent).Inc()
+			return false
+		}
+
+		metrics.ServicesDeregistered.WithLabelValues("consul", s.Agent).Inc()
+		return true
+	})
+
+	c.endCycle()
+}
+
+// cycleCall()
+//   Count an agent call of the current cycle
+//
+func (c *Consul) cycleCall(ok bool) {
+	c.cycleCalls++
+	if !ok {
+		c.cycleFailed++
 	}
 }
 
-func (c *Consul) deregister(agent string, service *consulapi.AgentServiceRegistration) error {
-	if _, ok := c.agents[agent]; !ok {
-		// Agent connection not saved. Connect.
-		c.agents[agent] = c.newAgent(agent)
+// endCycle()
+//   Record the outcome of the cycle and start a new one
+//
+func (c *Consul) endCycle() {
+	var err error
+	if c.cycleCalls > 0 && c.cycleFailed == c.cycleCalls {
+		err = fmt.Errorf("all %d Consul agent calls failed", c.cycleCalls)
+	}
+	c.cycleCalls = 0
+	c.cycleFailed = 0
+
+	c.cycleLock.Lock()
+	c.cycleErr = err
+	c.cycleLock.Unlock()
+}
+
+// CycleError()
+//   Return an error if every Consul agent call failed in the last cycle
+//
+func (c *Consul) CycleError() error {
+	c.cycleLock.Lock()
+	defer c.cycleLock.Unlock()
+
+	return c.cycleErr
+}
+
+func (c *Consul) deregister(agent string, id string) error {
+	client := c.client(agent)
+	if client == nil {
+		return fmt.Errorf("no Consul agent for %s", id)
 	}
 
-	return c.agents[agent].Agent().ServiceDeregister(service.ID)
+	return client.Agent().ServiceDeregister(id)
 }
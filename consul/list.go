@@ -0,0 +1,54 @@
+package consul
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mantl/mesos-consul/registry"
+)
+
+// List()
+//   List the services carrying the prefix on every agent. Checks are
+//   not listed, the agent services API doesn't return them.
+//
+func (c *Consul) List(agents []string, serviceIdPrefix string) ([]*registry.Service, error) {
+	searchStr := fmt.Sprintf("%s:", serviceIdPrefix)
+
+	var list []*registry.Service
+	seen := make(map[string]bool)
+	for _, host := range agents {
+		agent := c.agentAddress(host)
+		if agent == "" || seen[agent] {
+			continue
+		}
+		seen[agent] = true
+
+		client := c.client(agent)
+		if client == nil {
+			continue
+		}
+
+		services, err := client.Agent().Services()
+		if err != nil {
+			return nil, fmt.Errorf("unable to list services on %s: %s", agent, err)
+		}
+
+		for id, s := range services {
+			if !strings.HasPrefix(id, searchStr) {
+				continue
+			}
+
+			list = append(list, &registry.Service{
+				ID:      s.ID,
+				Name:    s.Service,
+				Port:    s.Port,
+				Address: s.Address,
+				Tags:    s.Tags,
+				Agent:   agent,
+				Meta:    s.Meta,
+			})
+		}
+	}
+
+	return list, nil
+}
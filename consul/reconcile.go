@@ -0,0 +1,116 @@
+package consul
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mantl/mesos-consul/metrics"
+	"github.com/mantl/mesos-consul/registry"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ReconcileStats counts the repairs made by Reconcile
+type ReconcileStats struct {
+	Reregistered int
+	Removed      int
+	Errors       int
+}
+
+// Reconcile()
+//   List the services of every agent and compare them with the cache.
+//   Services that are in the cache but missing from their agent are
+//   registered again, services carrying our prefix that are not in the
+//   cache are removed.
+//
+func (c *Consul) Reconcile(agents []string, serviceIdPrefix string) {
+	var stats ReconcileStats
+
+	searchStr := fmt.Sprintf("%s:", serviceIdPrefix)
+
+	// Services registered during the last cycle, by agent
+	desired := make(map[string]map[string]*registry.Service)
+	for _, id := range c.CacheIDs() {
+		e := c.Cached(id)
+		if e.InGrace() {
+			// Not seen during the last cycle. Leave it to Deregister()
+			continue
+		}
+		if _, ok := desired[e.Service.Agent]; !ok {
+			desired[e.Service.Agent] = make(map[string]*registry.Service)
+		}
+		desired[e.Service.Agent][id] = e.Service
+	}
+
+	seen := make(map[string]bool)
+	for _, host := range agents {
+		agent := c.agentAddress(host)
+		if agent == "" || seen[agent] {
+			continue
+		}
+		seen[agent] = true
+
+		client := c.client(agent)
+		if client == nil {
+			continue
+		}
+
+		services, err := client.Agent().Services()
+		if err != nil {
+			log.Warnf("Reconcile: unable to list services on %s: %s", agent, err.Error())
+			stats.Errors++
+			continue
+		}
+
+		for id, s := range desired[agent] {
+			if _, ok := services[id]; ok {
+				continue
+			}
+
+			log.Warnf("Reconcile: %s missing from %s. Re-registering", id, agent)
+			if c.config.dryRun {
+				log.Info("Dry run, not registering ", id)
+				continue
+			}
+
+			if err := client.Agent().ServiceRegister(c.registration(s)); err != nil {
+				log.Warnf("Reconcile: unable to register %s: %s", id, err.Error())
+				stats.Errors++
+				continue
+			}
+			stats.Reregistered++
+		}
+
+		for id := range services {
+			if !strings.HasPrefix(id, searchStr) {
+				continue
+			}
+			if e := c.Cached(id); e != nil && e.Service.Agent == agent {
+				continue
+			}
+
+			log.Warnf("Reconcile: %s on %s is not managed. Removing", id, agent)
+			if c.config.dryRun {
+				log.Info("Dry run, not deregistering ", id)
+				continue
+			}
+
+			if err := c.deregister(agent, id); err != nil {
+				log.Warnf("Reconcile: unable to deregister %s: %s", id, err.Error())
+				stats.Errors++
+				continue
+			}
+			stats.Removed++
+		}
+	}
+
+	metrics.ReconcileReregistered.WithLabelValues("consul").Add(float64(stats.Reregistered))
+	metrics.ReconcileRemoved.WithLabelValues("consul").Add(float64(stats.Removed))
+	metrics.ReconcileErrors.WithLabelValues("consul").Add(float64(stats.Errors))
+
+	if stats.Reregistered > 0 || stats.Removed > 0 || stats.Errors > 0 {
+		log.Warnf("Reconcile: re-registered %d, removed %d, errors %d", stats.Reregistered, stats.Removed, stats.Errors)
+	} else {
+		log.Info("Reconcile: all agents in sync")
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/mantl/mesos-consul/mesos"
+)
+
+// newDebugHandler serves a view of what mesos-consul registers
+// and why:
+//
+//   /v1/services     services generated by the last sync
+//   /v1/cache        registry cache entries
+//   /v1/tasks        filtering decision and service IDs of every task
+//   /v1/tasks/<id>   the same for a single task
+//   /v1/breaker      deregistrations held by the breaker
+//
+// POST /v1/breaker/release lets the held deregistrations go ahead.
+// It is the only call that changes anything, and is only served when
+// allowRelease is set.
+//
+func newDebugHandler(current func() *mesos.Mesos, allowRelease bool) http.Handler {
+	mux := http.NewServeMux()
+
+	handle := func(path, method string, f func(*mesos.Mesos, http.ResponseWriter, *http.Request)) {
+		mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != method {
+				writeJSON(w, http.StatusMethodNotAllowed, debugError{"method not allowed"})
+				return
+			}
+
+			m := current()
+			if m == nil {
+				writeJSON(w, http.StatusServiceUnavailable, debugError{"not connected to Mesos yet"})
+				return
+			}
+
+			f(m, w, r)
+		})
+	}
+
+	handle("/v1/services", "GET", func(m *mesos.Mesos, w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, m.Services())
+	})
+
+	handle("/v1/cache", "GET", func(m *mesos.Mesos, w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, m.Cache())
+	})
+
+	handle("/v1/grace", "GET", func(m *mesos.Mesos, w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, m.InGrace())
+	})
+
+	handle("/v1/tasks", "GET", func(m *mesos.Mesos, w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, m.Tasks())
+	})
+
+	handle("/v1/tasks/", "GET", func(m *mesos.Mesos, w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimPrefix(r.URL.Path, "/v1/tasks/")
+
+		d, ok := m.Task(id)
+		if !ok {
+			writeJSON(w, http.StatusNotFound, debugError{"task " + id + " not seen in the last sync"})
+			return
+		}
+
+		writeJSON(w, http.StatusOK, d)
+	})
+
+	handle("/v1/breaker", "GET", func(m *mesos.Mesos, w http.ResponseWriter, r *http.Request) {
+		held, enabled := m.BreakerHeld()
+		if held == nil {
+			held = []string{}
+		}
+		writeJSON(w, http.StatusOK, breakerStatus{Enabled: enabled, Held: held})
+	})
+
+	handle("/v1/breaker/release", "POST", func(m *mesos.Mesos, w http.ResponseWriter, r *http.Request) {
+		if !allowRelease {
+			writeJSON(w, http.StatusForbidden, debugError{"releasing the breaker is disabled, see --deregister-release-api"})
+			return
+		}
+
+		if !m.ReleaseBreaker() {
+			writeJSON(w, http.StatusNotFound, debugError{"the deregistration breaker is not enabled"})
+			return
+		}
+
+		held, _ := m.BreakerHeld()
+		writeJSON(w, http.StatusOK, breakerStatus{Enabled: true, Held: held, Released: true})
+	})
+
+	return mux
+}
+
+type breakerStatus struct {
+	Enabled  bool     `json:"enabled"`
+	Held     []string `json:"held"`
+	Released bool     `json:"released,omitempty"`
+}
+
+type debugError struct {
+	Error string `json:"error"`
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
@@ -0,0 +1,48 @@
+package etcd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/mantl/mesos-consul/registry"
+
+	"github.com/coreos/etcd/clientv3"
+	log "github.com/sirupsen/logrus"
+)
+
+// Initialize the service cache from the keys under the prefix.
+// The host is not used, every mesos-consul instance talks to the
+// same etcd cluster.
+//
+func (e *Etcd) CacheLoad(host, serviceIdPrefix string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), e.config.timeout)
+	defer cancel()
+
+	resp, err := e.client.Get(ctx, e.key(""), clientv3.WithPrefix())
+	if err != nil {
+		return err
+	}
+
+	searchStr := fmt.Sprintf("%s:", serviceIdPrefix)
+
+	for _, kv := range resp.Kvs {
+		s := new(registry.Service)
+		if err := json.Unmarshal(kv.Value, s); err != nil {
+			log.Warnf("Invalid service at %s: %s", kv.Key, err.Error())
+			continue
+		}
+
+		if strings.HasPrefix(s.ID, searchStr) {
+			log.Debugf("Found '%s' with ID '%s'", s.Name, s.ID)
+
+			// The key is attached to the lease of a previous run. Leave the
+			// fingerprint empty so that the next Register() puts it again
+			// with our lease.
+			e.CacheAdd(s, "")
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,42 @@
+package etcd
+
+import (
+	"time"
+
+	flag "github.com/ogier/pflag"
+)
+
+type etcdConfig struct {
+	endpoints string
+	prefix    string
+	ttl       time.Duration
+	timeout   time.Duration
+}
+
+var config etcdConfig
+
+func AddCmdFlags(f *flag.FlagSet) {
+	f.StringVar(&config.endpoints, "etcd-endpoints", "127.0.0.1:2379", "")
+	f.StringVar(&config.prefix, "etcd-prefix", "/mesos-consul/services", "")
+	f.DurationVar(&config.ttl, "etcd-ttl", time.Minute, "")
+	f.DurationVar(&config.timeout, "etcd-timeout", 5*time.Second, "")
+}
+
+func Help() string {
+	helpText := `
+Etcd Options:
+
+  --etcd-endpoints		Comma separated list of etcd v3 endpoints
+				(default: 127.0.0.1:2379)
+  --etcd-prefix			Key prefix under which services are written
+				(default: /mesos-consul/services)
+  --etcd-ttl			TTL of the lease attached to every service. Services
+				expire this long after mesos-consul stops
+				(default: 1m)
+  --etcd-timeout		Timeout of requests to etcd
+				(default: 5s)
+
+`
+
+	return helpText
+}
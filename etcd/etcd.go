@@ -0,0 +1,218 @@
+package etcd
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+
+	"github.com/mantl/mesos-consul/metrics"
+	"github.com/mantl/mesos-consul/registry"
+
+	"github.com/coreos/etcd/clientv3"
+	log "github.com/sirupsen/logrus"
+)
+
+// Etcd writes every service as JSON under a key prefix. All keys are
+// attached to a lease that is kept alive while mesos-consul runs, so
+// they expire if it dies.
+type Etcd struct {
+	registry.Cache
+
+	client *clientv3.Client
+	config etcdConfig
+	dryRun bool
+
+	lock      sync.Mutex
+	lease     clientv3.LeaseID
+	leaseLost bool
+}
+
+//
+func New(dryRun bool) (*Etcd, error) {
+	client, err := clientv3.New(clientv3.Config{
+		Endpoints:   strings.Split(config.endpoints, ","),
+		DialTimeout: config.timeout,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	e := &Etcd{
+		Cache:  registry.NewCache("etcd"),
+		client: client,
+		config: config,
+		dryRun: dryRun,
+	}
+
+	if !dryRun {
+		if err := e.grantLease(); err != nil {
+			client.Close()
+			return nil, err
+		}
+	}
+
+	return e, nil
+}
+
+// key()
+//   Return the etcd key of a service ID
+//
+func (e *Etcd) key(id string) string {
+	return strings.TrimRight(e.config.prefix, "/") + "/" + id
+}
+
+// grantLease()
+//   Grant a new lease and keep it alive in the background
+//
+func (e *Etcd) grantLease() error {
+	ttl := int64(e.config.ttl.Seconds())
+	if ttl < 1 {
+		ttl = 1
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), e.config.timeout)
+	defer cancel()
+
+	resp, err := e.client.Grant(ctx, ttl)
+	if err != nil {
+		return err
+	}
+
+	ch, err := e.client.KeepAlive(context.Background(), resp.ID)
+	if err != nil {
+		return err
+	}
+
+	log.Debugf("Granted etcd lease %x with a TTL of %ds", resp.ID, ttl)
+
+	e.lock.Lock()
+	e.lease = resp.ID
+	e.leaseLost = false
+	e.lock.Unlock()
+
+	go e.keepAlive(resp.ID, ch)
+
+	return nil
+}
+
+// keepAlive()
+//   Drain the keep alive responses. The channel is closed
+//   when the lease can't be kept alive anymore.
+//
+func (e *Etcd) keepAlive(id clientv3.LeaseID, ch <-chan *clientv3.LeaseKeepAliveResponse) {
+	for range ch {
+	}
+
+	log.Warnf("Lost etcd lease %x", id)
+
+	e.lock.Lock()
+	if e.lease == id {
+		e.leaseLost = true
+	}
+	e.lock.Unlock()
+}
+
+// currentLease()
+//   Return the lease to attach keys to. A new one is granted if the
+//   previous one was lost. The keys attached to the lost lease are
+//   gone, so the fingerprints are cleared to put every service again.
+//
+func (e *Etcd) currentLease() (clientv3.LeaseID, error) {
+	e.lock.Lock()
+	lost := e.leaseLost
+	e.lock.Unlock()
+
+	if lost {
+		if err := e.grantLease(); err != nil {
+			return clientv3.NoLease, err
+		}
+
+		for _, id := range e.CacheIDs() {
+			e.Cached(id).Fingerprint = ""
+		}
+	}
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	return e.lease, nil
+}
+
+func (e *Etcd) Register(service *registry.Service) {
+	fingerprint := service.Fingerprint()
+
+	if c := e.Cached(service.ID); c != nil && c.Fingerprint == fingerprint {
+		log.Debugf("Service found. Not registering: %s", service.ID)
+		metrics.ServicesSkipped.WithLabelValues("etcd", service.Agent, service.Framework).Inc()
+		c.Service.Grace = service.Grace
+		e.CacheMark(service.ID)
+		return
+	}
+
+	if e.dryRun {
+		log.Info("Dry run, not registering ", service.ID)
+		e.CacheMark(service.ID)
+		return
+	}
+
+	lease, err := e.currentLease()
+	if err != nil {
+		log.Warnf("Unable to grant etcd lease: %s", err.Error())
+		metrics.RegistrationErrors.WithLabelValues("etcd", service.Agent).Inc()
+		return
+	}
+
+	log.Info("Registering ", service.ID)
+
+	s := *service
+	s.Tags = make([]string, len(service.Tags))
+	copy(s.Tags, service.Tags)
+
+	value, err := json.Marshal(&s)
+	if err != nil {
+		log.Warnf("Unable to encode %s: %s", s.ID, err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), e.config.timeout)
+	defer cancel()
+
+	_, err = e.client.Put(ctx, e.key(s.ID), string(value), clientv3.WithLease(lease))
+	if err != nil {
+		log.Warnf("Unable to register %s: %s", s.ID, err.Error())
+		metrics.RegistrationErrors.WithLabelValues("etcd", s.Agent).Inc()
+		return
+	}
+
+	metrics.ServicesRegistered.WithLabelValues("etcd", s.Agent, s.Framework).Inc()
+	e.CacheAdd(&s, fingerprint)
+}
+
+// Deregister()
+//   Deregister services that no longer exist
+//
+func (e *Etcd) Deregister() {
+	e.CacheSweep(func(c *registry.CachedService) bool {
+		id := c.Service.ID
+		if e.dryRun {
+			log.Info("Dry run, not deregistering ", id)
+			return true
+		}
+
+		log.Infof("Deregistering %s", id)
+
+		ctx, cancel := context.WithTimeout(context.Background(), e.config.timeout)
+		_, err := e.client.Delete(ctx, e.key(id))
+		cancel()
+
+		if err != nil {
+			log.Info("Deregistration error ", err)
+			metrics.RegistrationErrors.WithLabelValues("etcd", c.Service.Agent).Inc()
+			return false
+		}
+
+		metrics.ServicesDeregistered.WithLabelValues("etcd", c.Service.Agent).Inc()
+		return true
+	})
+}
@@ -0,0 +1,165 @@
+package etcd
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/mantl/mesos-consul/registry"
+
+	"github.com/coreos/etcd/embed"
+)
+
+// startEtcd starts an embedded etcd server listening on the default
+// client URL.
+func startEtcd(t *testing.T) func() {
+	dir, err := ioutil.TempDir("", "mesos-consul-etcd")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := embed.NewConfig()
+	cfg.Dir = dir
+
+	srv, err := embed.StartEtcd(cfg)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+
+	select {
+	case <-srv.Server.ReadyNotify():
+	case <-time.After(30 * time.Second):
+		srv.Close()
+		os.RemoveAll(dir)
+		t.Fatal("embedded etcd took too long to start")
+	}
+
+	return func() {
+		srv.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestEtcdRegistry(t *testing.T) {
+	stop := startEtcd(t)
+	defer stop()
+
+	config = etcdConfig{
+		endpoints: "localhost:2379",
+		prefix:    "/mesos-consul-test/",
+		ttl:       10 * time.Second,
+		timeout:   5 * time.Second,
+	}
+
+	e, err := New(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.client.Close()
+
+	if !e.CacheCreate() {
+		t.Fatal("CacheCreate() should create the cache on first call")
+	}
+
+	s := &registry.Service{
+		ID:      "mesos-consul:10.0.0.1:web:10.0.0.1:31000",
+		Name:    "web",
+		Port:    31000,
+		Address: "10.0.0.1",
+		Agent:   "10.0.0.1",
+		Tags:    []string{"a"},
+		Check:   registry.DefaultCheck(),
+	}
+
+	get := func() (*registry.Service, int64, int64) {
+		resp, err := e.client.Get(context.Background(), "/mesos-consul-test/"+s.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(resp.Kvs) == 0 {
+			return nil, 0, 0
+		}
+
+		var got registry.Service
+		if err := json.Unmarshal(resp.Kvs[0].Value, &got); err != nil {
+			t.Fatal(err)
+		}
+
+		return &got, resp.Kvs[0].ModRevision, resp.Kvs[0].Lease
+	}
+
+	e.Register(s)
+	got, rev, lease := get()
+	if got == nil || got.ID != s.ID || got.Port != s.Port {
+		t.Fatalf("registered service => %+v, want %+v", got, s)
+	}
+	if lease == 0 {
+		t.Error("service key is not attached to a lease")
+	}
+
+	e.Register(s)
+	if _, rev2, _ := get(); rev2 != rev {
+		t.Error("unchanged service was written again")
+	}
+
+	s.Tags = []string{"a", "b"}
+	e.Register(s)
+	if got, rev2, _ := get(); rev2 == rev || len(got.Tags) != 2 {
+		t.Errorf("changed service was not written again: %+v", got)
+	}
+
+	// A second instance finds the service
+	e2, err := New(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e2.client.Close()
+	e2.CacheCreate()
+	if err := e2.CacheLoad("", "mesos-consul"); err != nil {
+		t.Fatal(err)
+	}
+	if e2.CacheLookup(s.ID) == nil {
+		t.Error("CacheLoad() didn't find the registered service")
+	}
+
+	// Seen in the last cycle, kept
+	e.Deregister()
+	if got, _, _ := get(); got == nil {
+		t.Fatal("service removed before the validity threshold")
+	}
+
+	// Missed a cycle, removed
+	e.Deregister()
+	if got, _, _ := get(); got != nil {
+		t.Error("service not removed after the validity threshold")
+	}
+	if e.CacheLookup(s.ID) != nil {
+		t.Error("service not removed from the cache")
+	}
+}
+
+func TestEtcdDryRun(t *testing.T) {
+	// A dry run never talks to etcd
+	e := &Etcd{Cache: registry.NewCache("etcd"), dryRun: true}
+	e.CacheCreate()
+
+	kept := &registry.Service{ID: "mesos-consul:10.0.0.1:web:31000", Name: "web", Port: 31000}
+	gone := &registry.Service{ID: "mesos-consul:10.0.0.1:old:31001", Name: "old", Port: 31001}
+	e.CacheAdd(kept, "")
+	e.CacheAdd(gone, "")
+
+	// The services loaded count as seen by the first sync
+	for i := 0; i < 2; i++ {
+		e.Register(kept)
+		e.Register(&registry.Service{ID: "mesos-consul:10.0.0.1:new:31002", Name: "new", Port: 31002})
+		e.Deregister()
+	}
+
+	if got := e.CacheIDs(); len(got) != 1 || got[0] != kept.ID {
+		t.Errorf("cache after a dry run = %v, want %s", got, kept.ID)
+	}
+}
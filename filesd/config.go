@@ -0,0 +1,53 @@
+package filesd
+
+import (
+	"strings"
+
+	flag "github.com/ogier/pflag"
+)
+
+type fileSDConfig struct {
+	paths []string
+}
+
+var config fileSDConfig
+
+func AddCmdFlags(f *flag.FlagSet) {
+	f.Var(newPathsVar(&config.paths), "file-sd-path", "")
+}
+
+func Help() string {
+	helpText := `
+Prometheus file_sd Options:
+
+  --file-sd-path=<path>		File to write the services to, in the Prometheus
+				file_sd format. Files ending in .yml or .yaml are
+				written as YAML, others as JSON.
+				Can be specified multiple times
+
+`
+
+	return helpText
+}
+
+// pathsVar implements the Flag.Value interface and collects every
+// occurrence of the flag.
+type pathsVar []string
+
+// newPathsVar()
+//   Bind the flag to p, starting empty like the other flags start
+//   from their default
+//
+func newPathsVar(p *[]string) *pathsVar {
+	*p = nil
+	return (*pathsVar)(p)
+}
+
+func (p *pathsVar) Set(value string) error {
+	*p = append(*p, value)
+	return nil
+}
+
+func (p *pathsVar) String() string {
+	return strings.Join(*p, ",")
+}
@@ -0,0 +1,196 @@
+package filesd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/mantl/mesos-consul/metrics"
+	"github.com/mantl/mesos-consul/registry"
+
+	log "github.com/sirupsen/logrus"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// FileSD writes the services as Prometheus file_sd target groups.
+// Files are written at the end of every cycle, and only when their
+// content changed.
+type FileSD struct {
+	registry.Cache
+
+	paths  []string
+	dryRun bool
+
+	written map[string][]byte
+}
+
+// targetGroup is a single entry of a file_sd file
+type targetGroup struct {
+	Targets []string          `json:"targets" yaml:"targets"`
+	Labels  map[string]string `json:"labels" yaml:"labels"`
+}
+
+//
+func New(dryRun bool) (*FileSD, error) {
+	if len(config.paths) == 0 {
+		return nil, errors.New("no --file-sd-path given")
+	}
+
+	return &FileSD{
+		Cache:   registry.NewCache("file-sd"),
+		paths:   config.paths,
+		dryRun:  dryRun,
+		written: make(map[string][]byte),
+	}, nil
+}
+
+func (f *FileSD) Register(service *registry.Service) {
+	if f.Cached(service.ID) == nil {
+		log.Info("Adding target ", service.ID)
+		metrics.ServicesRegistered.WithLabelValues("file-sd", service.Agent, service.Framework).Inc()
+	} else {
+		metrics.ServicesSkipped.WithLabelValues("file-sd", service.Agent, service.Framework).Inc()
+	}
+
+	s := *service
+	s.Tags = make([]string, len(service.Tags))
+	copy(s.Tags, service.Tags)
+
+	f.CacheAdd(&s, "")
+}
+
+// Deregister()
+//   Drop the services that no longer exist and write the files
+//
+func (f *FileSD) Deregister() {
+	f.CacheSweep(func(c *registry.CachedService) bool {
+		log.Info("Removing target ", c.Service.ID)
+		metrics.ServicesDeregistered.WithLabelValues("file-sd", c.Service.Agent).Inc()
+		return true
+	})
+
+	groups := f.targetGroups()
+
+	for _, path := range f.paths {
+		content, err := render(path, groups)
+		if err != nil {
+			log.Warnf("Unable to render %s: %s", path, err.Error())
+			continue
+		}
+
+		if bytes.Equal(content, f.written[path]) {
+			log.Debugf("%s unchanged", path)
+			continue
+		}
+
+		if f.dryRun {
+			log.Info("Dry run, not writing ", path)
+			continue
+		}
+
+		if err := writeFile(path, content); err != nil {
+			log.Warnf("Unable to write %s: %s", path, err.Error())
+			continue
+		}
+
+		log.Infof("Wrote %d target groups to %s", len(groups), path)
+		f.written[path] = content
+	}
+}
+
+// targetGroups()
+//   Build one target group per service, ordered by service ID
+//
+func (f *FileSD) targetGroups() []targetGroup {
+	ids := f.CacheIDs()
+
+	groups := make([]targetGroup, 0, len(ids))
+	for _, id := range ids {
+		s := f.Cached(id).Service
+
+		target := s.Address
+		if s.Port != 0 {
+			target = s.Address + ":" + strconv.Itoa(s.Port)
+		}
+
+		labels := map[string]string{
+			"__meta_mesos_service_id":   s.ID,
+			"__meta_mesos_service_name": s.Name,
+			"__meta_mesos_agent":        s.Agent,
+		}
+		if len(s.Tags) > 0 {
+			// Surrounded by separators like __meta_consul_tags,
+			// so that a tag can be matched with ".*,tag,.*"
+			labels["__meta_mesos_tags"] = "," + strings.Join(s.Tags, ",") + ","
+		}
+		if s.Framework != "" {
+			labels["__meta_mesos_framework"] = s.Framework
+		}
+		if s.TaskID != "" {
+			labels["__meta_mesos_task_id"] = s.TaskID
+		}
+		for k, v := range s.Meta {
+			// Like __meta_consul_service_metadata_<key>
+			labels["__meta_mesos_service_metadata_"+strings.Replace(k, "-", "_", -1)] = v
+		}
+
+		groups = append(groups, targetGroup{
+			Targets: []string{target},
+			Labels:  labels,
+		})
+	}
+
+	return groups
+}
+
+// render()
+//   Encode the target groups in the format matching the file extension
+//
+func render(path string, groups []targetGroup) ([]byte, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yml", ".yaml":
+		return yaml.Marshal(groups)
+	default:
+		b, err := json.MarshalIndent(groups, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		return append(b, '\n'), nil
+	}
+}
+
+// writeFile()
+//   Atomically replace the file: write a temporary file in the same
+//   directory and rename it over the target.
+//
+func writeFile(path string, content []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".")
+	if err != nil {
+		return err
+	}
+
+	_, err = tmp.Write(content)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+
+	return err
+}
@@ -0,0 +1,159 @@
+package filesd
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mantl/mesos-consul/registry"
+)
+
+func TestFileSD(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mesos-consul-filesd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	jsonPath := filepath.Join(dir, "targets.json")
+	yamlPath := filepath.Join(dir, "targets.yml")
+
+	config = fileSDConfig{paths: []string{jsonPath, yamlPath}}
+	f, err := New(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.CacheCreate()
+
+	web := &registry.Service{
+		ID:        "mesos-consul:10.0.0.1:web:10.0.0.1:31000",
+		Name:      "web",
+		Port:      31000,
+		Address:   "10.0.0.1",
+		Agent:     "10.0.0.1",
+		Tags:      []string{"a", "b"},
+		Meta:      map[string]string{"app-version": "2"},
+		TaskID:    "web.1",
+		Framework: "marathon",
+	}
+	master := &registry.Service{
+		ID:      "mesos-consul:mesos:10.0.0.10:5050",
+		Name:    "mesos",
+		Port:    5050,
+		Address: "10.0.0.10",
+		Agent:   "10.0.0.10",
+	}
+
+	f.Register(web)
+	f.Register(master)
+	f.Deregister()
+
+	var groups []targetGroup
+	b, err := ioutil.ReadFile(jsonPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(b, &groups); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(groups) != 2 {
+		t.Fatalf("got %d target groups, want 2", len(groups))
+	}
+	if g := groups[0]; g.Targets[0] != "10.0.0.1:31000" ||
+		g.Labels["__meta_mesos_tags"] != ",a,b," ||
+		g.Labels["__meta_mesos_framework"] != "marathon" ||
+		g.Labels["__meta_mesos_task_id"] != "web.1" ||
+		g.Labels["__meta_mesos_service_metadata_app_version"] != "2" ||
+		g.Labels["__meta_mesos_agent"] != "10.0.0.1" {
+		t.Errorf("unexpected target group: %+v", g)
+	}
+	if _, ok := groups[1].Labels["__meta_mesos_task_id"]; ok {
+		t.Error("empty labels should be left out")
+	}
+
+	y, err := ioutil.ReadFile(yamlPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(y), "- 10.0.0.1:31000") {
+		t.Errorf("YAML output is missing the target:\n%s", y)
+	}
+
+	// Unchanged content isn't written again
+	past := time.Now().Add(-time.Hour)
+	os.Chtimes(jsonPath, past, past)
+	f.Register(web)
+	f.Register(master)
+	f.Deregister()
+	if st, _ := os.Stat(jsonPath); !st.ModTime().Equal(past) {
+		t.Error("unchanged file was written again")
+	}
+
+	// A service missing for a cycle is removed
+	f.Register(master)
+	f.Deregister()
+	f.Register(master)
+	f.Deregister()
+
+	b, _ = ioutil.ReadFile(jsonPath)
+	groups = nil
+	json.Unmarshal(b, &groups)
+	if len(groups) != 1 || groups[0].Labels["__meta_mesos_service_id"] != master.ID {
+		t.Errorf("unexpected target groups after removal: %+v", groups)
+	}
+
+	if files, _ := ioutil.ReadDir(dir); len(files) != 2 {
+		t.Errorf("temporary files left behind: %d files", len(files))
+	}
+}
+
+func TestFileSDGrace(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mesos-consul-filesd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	config = fileSDConfig{paths: []string{filepath.Join(dir, "targets.json")}}
+	f, err := New(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.CacheCreate()
+
+	f.Register(&registry.Service{ID: "short", Address: "10.0.0.1"})
+	f.Register(&registry.Service{ID: "cycles", Address: "10.0.0.1", Grace: &registry.Grace{Cycles: 3}})
+	f.Register(&registry.Service{ID: "time", Address: "10.0.0.1", Grace: &registry.Grace{Duration: time.Hour}})
+	f.Deregister()
+
+	var left []string
+	for i := 0; i < 2; i++ {
+		f.Deregister()
+
+		left = nil
+		for _, e := range f.CacheDump() {
+			left = append(left, e.Service.ID)
+		}
+	}
+
+	if !reflect.DeepEqual(left, []string{"cycles", "time"}) {
+		t.Errorf("services left after 2 missed cycles: %v", left)
+	}
+
+	for _, e := range f.CacheDump() {
+		if e.ValidityCounter != 3 || e.MissingSince == nil {
+			t.Errorf("unexpected cache entry: %+v", e)
+		}
+	}
+
+	f.Deregister()
+	if d := f.CacheDump(); len(d) != 1 || d[0].Service.ID != "time" || d[0].GraceDuration != "1h0m0s" {
+		t.Errorf("unexpected cache after 3 missed cycles: %+v", d)
+	}
+}
@@ -0,0 +1,283 @@
+// Package ha elects the active mesos-consul instance among several
+// replicas. Every replica creates an ephemeral sequential znode, and
+// the replica with the lowest sequence number is the leader. The others
+// watch the node just before theirs, so that only one of them is woken
+// up when the leader goes away.
+package ha
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mantl/mesos-consul/metrics"
+
+	"github.com/samuel/go-zookeeper/zk"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	nodePrefix = "member-"
+	retryDelay = time.Second
+)
+
+// zkConn is the part of a Zookeeper connection the election uses
+type zkConn interface {
+	Children(path string) ([]string, *zk.Stat, error)
+	ExistsW(path string) (bool, *zk.Stat, <-chan zk.Event, error)
+	Create(path string, data []byte, flags int32, acl []zk.ACL) (string, error)
+}
+
+type Election struct {
+	conn    zkConn
+	session <-chan zk.Event
+	path    string
+
+	// ACL of the znodes we create
+	acl []zk.ACL
+
+	// Our znode, empty when it has to be created
+	node string
+
+	// Called in a new goroutine when this instance becomes the leader
+	OnElected func()
+
+	lock   sync.Mutex
+	leader bool
+}
+
+// New connects to the Zookeeper servers of a zk:// URL. The election
+// znodes are created under path. auth is the user:password of the
+// digest authentication, if any. The znodes are then only writable
+// with the same credentials.
+func New(zkURL, path, auth string, connectTimeout, sessionTimeout time.Duration) (*Election, error) {
+	servers, err := zkServers(zkURL)
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := func(network, address string, _ time.Duration) (net.Conn, error) {
+		return net.DialTimeout(network, address, connectTimeout)
+	}
+
+	conn, session, err := zk.Connect(servers, sessionTimeout, zk.WithDialer(dialer))
+	if err != nil {
+		return nil, err
+	}
+
+	acl := zk.WorldACL(zk.PermAll)
+	if auth != "" {
+		if err := conn.AddAuth("digest", []byte(auth)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+
+		parts := strings.SplitN(auth, ":", 2)
+		acl = zk.DigestACL(zk.PermAll, parts[0], parts[1])
+	}
+
+	return newElection(conn, session, path, acl), nil
+}
+
+// newElection runs an election on an open connection
+func newElection(conn zkConn, session <-chan zk.Event, path string, acl []zk.ACL) *Election {
+	return &Election{
+		conn:    conn,
+		session: session,
+		path:    strings.TrimSuffix(path, "/"),
+		acl:     acl,
+	}
+}
+
+// zkServers returns the servers of a zk://host:port,host:port/path URL
+func zkServers(zkURL string) ([]string, error) {
+	u, err := url.Parse(zkURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "zk" || u.Host == "" {
+		return nil, fmt.Errorf("invalid Zookeeper URL '%s'", zkURL)
+	}
+
+	return strings.Split(u.Host, ","), nil
+}
+
+// IsLeader returns true while this instance holds the leadership
+func (e *Election) IsLeader() bool {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	return e.leader
+}
+
+func (e *Election) setLeader(leader bool) {
+	e.lock.Lock()
+	changed := e.leader != leader
+	e.leader = leader
+	e.lock.Unlock()
+
+	if !changed {
+		return
+	}
+
+	if leader {
+		log.Warn("HA: elected leader, syncing")
+		metrics.HALeader.Set(1)
+		if e.OnElected != nil {
+			go e.OnElected()
+		}
+	} else {
+		log.Warn("HA: lost leadership, standing by")
+		metrics.HALeader.Set(0)
+	}
+	metrics.HATransitions.Inc()
+}
+
+// Run takes part in the election until the process exits
+func (e *Election) Run() {
+	for {
+		if err := e.campaign(); err != nil {
+			log.Warn("HA: ", err)
+			e.setLeader(false)
+			time.Sleep(retryDelay)
+		}
+	}
+}
+
+// campaign creates our znode if needed, checks our position and waits
+// for something to change
+func (e *Election) campaign() error {
+	if err := e.createNode(); err != nil {
+		return err
+	}
+
+	children, _, err := e.conn.Children(e.path)
+	if err != nil {
+		return err
+	}
+
+	leader, watch := position(children, path.Base(e.node))
+	if watch == "" && !leader {
+		// Our node is gone, the session expired
+		e.node = ""
+		return errors.New("election node lost")
+	}
+
+	if leader {
+		watch = path.Base(e.node)
+	}
+
+	exists, _, ch, err := e.conn.ExistsW(e.path + "/" + watch)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		if leader {
+			e.node = ""
+		}
+		return nil
+	}
+
+	e.setLeader(leader)
+
+	for {
+		select {
+		case ev := <-ch:
+			if ev.Type == zk.EventNodeDeleted && leader {
+				e.node = ""
+			}
+			return nil
+
+		case ev := <-e.session:
+			switch ev.State {
+			case zk.StateDisconnected:
+				// The session may still expire, and another
+				// instance take over. Stop writing until we know.
+				e.setLeader(false)
+			case zk.StateExpired:
+				e.node = ""
+				return errors.New("Zookeeper session expired")
+			case zk.StateHasSession:
+				return nil
+			}
+		}
+	}
+}
+
+// createNode creates the election path and our ephemeral sequential node
+func (e *Election) createNode() error {
+	if e.node != "" {
+		return nil
+	}
+
+	if err := e.createPath(); err != nil {
+		return err
+	}
+
+	host, _ := os.Hostname()
+	node, err := e.conn.Create(e.path+"/"+nodePrefix, []byte(host), zk.FlagEphemeral|zk.FlagSequence, e.acl)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("HA: joined the election as %s", node)
+	e.node = node
+
+	return nil
+}
+
+// createPath creates every missing parent of the election path
+func (e *Election) createPath() error {
+	p := ""
+	for _, part := range strings.Split(strings.Trim(e.path, "/"), "/") {
+		p += "/" + part
+		_, err := e.conn.Create(p, nil, 0, e.acl)
+		if err != nil && err != zk.ErrNodeExists {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// position returns whether node is the leader among the children of
+// the election path, and otherwise the node just before it, which is
+// empty if node isn't one of the children
+func position(children []string, node string) (bool, string) {
+	var members []string
+	for _, c := range children {
+		if strings.HasPrefix(c, nodePrefix) {
+			members = append(members, c)
+		}
+	}
+	// The sequence numbers have a fixed width
+	sort.Strings(members)
+
+	for i, m := range members {
+		if m != node {
+			continue
+		}
+		if i == 0 {
+			return true, ""
+		}
+		return false, members[i-1]
+	}
+
+	return false, ""
+}
+
+// Role returns "leader" or "standby"
+func (e *Election) Role() string {
+	if e.IsLeader() {
+		return "leader"
+	}
+
+	return "standby"
+}
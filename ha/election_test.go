@@ -0,0 +1,189 @@
+package ha
+
+import (
+	"fmt"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+// fakeZk is an in-memory Zookeeper shared by the elections of a test
+type fakeZk struct {
+	lock    sync.Mutex
+	seq     int
+	nodes   map[string][]zk.ACL
+	watches map[string][]chan zk.Event
+}
+
+func newFakeZk() *fakeZk {
+	return &fakeZk{
+		nodes:   make(map[string][]zk.ACL),
+		watches: make(map[string][]chan zk.Event),
+	}
+}
+
+func (f *fakeZk) Create(p string, data []byte, flags int32, acl []zk.ACL) (string, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if flags&zk.FlagSequence != 0 {
+		p = fmt.Sprintf("%s%010d", p, f.seq)
+		f.seq++
+	}
+	if _, ok := f.nodes[p]; ok {
+		return "", zk.ErrNodeExists
+	}
+	f.nodes[p] = acl
+
+	return p, nil
+}
+
+func (f *fakeZk) Children(p string) ([]string, *zk.Stat, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	var children []string
+	for n := range f.nodes {
+		if path.Dir(n) == p {
+			children = append(children, path.Base(n))
+		}
+	}
+	sort.Strings(children)
+
+	return children, nil, nil
+}
+
+func (f *fakeZk) ExistsW(p string) (bool, *zk.Stat, <-chan zk.Event, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	ch := make(chan zk.Event, 1)
+	f.watches[p] = append(f.watches[p], ch)
+	_, ok := f.nodes[p]
+
+	return ok, nil, ch, nil
+}
+
+// delete removes a node, as when the session of its owner expires
+func (f *fakeZk) delete(p string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	delete(f.nodes, p)
+	for _, ch := range f.watches[p] {
+		ch <- zk.Event{Type: zk.EventNodeDeleted, Path: p}
+	}
+	delete(f.watches, p)
+}
+
+// members returns the election nodes, in order
+func (f *fakeZk) members(p string) []string {
+	children, _, _ := f.Children(p)
+
+	var members []string
+	for _, c := range children {
+		if strings.HasPrefix(c, nodePrefix) {
+			members = append(members, p+"/"+c)
+		}
+	}
+
+	return members
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestElection(t *testing.T) {
+	f := newFakeZk()
+	acl := zk.DigestACL(zk.PermAll, "user", "secret")
+
+	elected := make(chan string, 2)
+	newMember := func(name string) *Election {
+		e := newElection(f, make(chan zk.Event), "/mesos-consul/", acl)
+		e.OnElected = func() { elected <- name }
+		go e.Run()
+		return e
+	}
+
+	a := newMember("a")
+	waitFor(t, "a to lead", a.IsLeader)
+	if name := <-elected; name != "a" {
+		t.Errorf("OnElected called for %s, want a", name)
+	}
+
+	b := newMember("b")
+	waitFor(t, "b to join", func() bool { return len(f.members("/mesos-consul")) == 2 })
+	time.Sleep(50 * time.Millisecond)
+	if b.IsLeader() || !a.IsLeader() {
+		t.Fatalf("leaders a=%v b=%v, want only a", a.IsLeader(), b.IsLeader())
+	}
+
+	for _, n := range append(f.members("/mesos-consul"), "/mesos-consul") {
+		f.lock.Lock()
+		got := f.nodes[n]
+		f.lock.Unlock()
+		if !reflect.DeepEqual(got, acl) {
+			t.Errorf("%s created with ACL %v, want %v", n, got, acl)
+		}
+	}
+
+	// The session of a expires: b takes over and a joins again behind it
+	f.delete(f.members("/mesos-consul")[0])
+	waitFor(t, "b to lead", b.IsLeader)
+	waitFor(t, "a to stand by", func() bool { return !a.IsLeader() })
+	if name := <-elected; name != "b" {
+		t.Errorf("OnElected called for %s, want b", name)
+	}
+	if n := len(f.members("/mesos-consul")); n != 2 {
+		t.Errorf("%d members, want 2", n)
+	}
+}
+
+func TestPosition(t *testing.T) {
+	children := []string{"member-0000000012", "member-0000000003", "other", "member-0000000007"}
+
+	tests := []struct {
+		node   string
+		leader bool
+		watch  string
+	}{
+		{"member-0000000003", true, ""},
+		{"member-0000000007", false, "member-0000000003"},
+		{"member-0000000012", false, "member-0000000007"},
+		{"member-0000000001", false, ""},
+	}
+
+	for _, tt := range tests {
+		leader, watch := position(children, tt.node)
+		if leader != tt.leader || watch != tt.watch {
+			t.Errorf("position(%s) => %v, %q, want %v, %q", tt.node, leader, watch, tt.leader, tt.watch)
+		}
+	}
+}
+
+func TestZkServers(t *testing.T) {
+	servers, err := zkServers("zk://zk1:2181,zk2:2181/mesos")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(servers) != 2 || servers[0] != "zk1:2181" || servers[1] != "zk2:2181" {
+		t.Errorf("servers => %q", servers)
+	}
+
+	if _, err := zkServers("http://zk1:2181/mesos"); err == nil {
+		t.Error("accepted a non zk:// URL")
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/mantl/mesos-consul/config"
+	"github.com/mantl/mesos-consul/mesos"
+	"github.com/mantl/mesos-consul/metrics"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// health reports whether mesos-consul keeps the registry in sync
+type health struct {
+	sync.Mutex
+	mesos *mesos.Mesos
+
+	// A sync older than maxAge makes the check fail
+	maxAge  time.Duration
+	started time.Time
+
+	// Where the leading master comes from, for the reasons
+	source string
+}
+
+// healthStatus is the body of a /health response
+type healthStatus struct {
+	Status       string     `json:"status"`
+	Role         string     `json:"role,omitempty"`
+	Reasons      []string   `json:"reasons,omitempty"`
+	LastSync     *time.Time `json:"last_sync,omitempty"`
+	ZkConnecting bool       `json:"zk_connecting,omitempty"`
+	ZkDegraded   string     `json:"zk_degraded,omitempty"`
+}
+
+func newHealth(c *config.Config) *health {
+	return &health{
+		maxAge:  time.Duration(c.HealthcheckMaxRefreshes) * c.Refresh,
+		started: time.Now(),
+		source:  leaderSource(c),
+	}
+}
+
+// leaderSource names where the leading master comes from
+func leaderSource(c *config.Config) string {
+	switch {
+	case c.MesosStateFile != "":
+		return "the state files"
+	case c.MesosAgent != "":
+		return "the agent"
+	case c.MesosMasters != "":
+		return "--mesos-masters"
+	default:
+		return "Zookeeper"
+	}
+}
+
+// setMesos starts checking m once it is connected to Zookeeper
+func (h *health) setMesos(m *mesos.Mesos) {
+	h.Lock()
+	defer h.Unlock()
+
+	h.mesos = m
+}
+
+// current returns the Mesos poller, or nil while connecting to Zookeeper
+func (h *health) current() *mesos.Mesos {
+	h.Lock()
+	defer h.Unlock()
+
+	return h.mesos
+}
+
+func (h *health) status() healthStatus {
+	m := h.current()
+	if m == nil {
+		return checkHealth(false, h.source, time.Time{}, h.started, h.maxAge, nil)
+	}
+
+	s := checkHealth(m.HasLeader(), h.source, m.LastSync(), h.started, h.maxAge, m.RegistryError())
+	s.Role = m.Role()
+	s.ZkConnecting = m.ZkConnecting()
+	s.ZkDegraded = m.ZkDegraded()
+
+	return s
+}
+
+// checkHealth returns the reasons why mesos-consul is unhealthy, if any.
+// Until the first sync, the time since start counts as the sync age.
+func checkHealth(hasLeader bool, source string, lastSync, started time.Time, maxAge time.Duration, registryErr error) healthStatus {
+	var s healthStatus
+
+	if !hasLeader {
+		s.Reasons = append(s.Reasons, "no leading master known from "+source)
+	}
+
+	if lastSync.IsZero() {
+		if age := time.Since(started); age > maxAge {
+			s.Reasons = append(s.Reasons, fmt.Sprintf("no successful sync since start %s ago", roundSecond(age)))
+		}
+	} else {
+		s.LastSync = &lastSync
+		if age := time.Since(lastSync); age > maxAge {
+			s.Reasons = append(s.Reasons, fmt.Sprintf("last successful sync %s ago, more than %s", roundSecond(age), maxAge))
+		}
+	}
+
+	if registryErr != nil {
+		s.Reasons = append(s.Reasons, registryErr.Error())
+	}
+
+	s.Status = "OK"
+	if len(s.Reasons) > 0 {
+		s.Status = "FAIL"
+	}
+
+	return s
+}
+
+func roundSecond(d time.Duration) time.Duration {
+	return d - d%time.Second
+}
+
+func (h *health) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s := h.status()
+
+	w.Header().Set("Content-Type", "application/json")
+	if len(s.Reasons) > 0 {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	json.NewEncoder(w).Encode(s)
+}
+
+func StartHealthcheckService(c *config.Config, h *health) {
+	http.Handle("/health", h)
+	http.Handle("/metrics", metrics.Handler())
+	http.Handle("/v1/", newDebugHandler(h.current, c.DeregisterReleaseAPI))
+	log.Fatal(http.ListenAndServe(fmt.Sprintf("%s:%s", c.HealthcheckIp, c.HealthcheckPort), nil))
+}
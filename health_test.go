@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mantl/mesos-consul/config"
+)
+
+func TestCheckHealth(t *testing.T) {
+	now := time.Now()
+	maxAge := 3 * time.Minute
+
+	tests := []struct {
+		name      string
+		hasLeader bool
+		lastSync  time.Time
+		started   time.Time
+		err       error
+		reasons   int
+	}{
+		{"healthy", true, now.Add(-time.Minute), now.Add(-time.Hour), nil, 0},
+		{"starting", true, time.Time{}, now.Add(-time.Minute), nil, 0},
+		{"never synced", true, time.Time{}, now.Add(-time.Hour), nil, 1},
+		{"stale", true, now.Add(-5 * time.Minute), now.Add(-time.Hour), nil, 1},
+		{"no leader", false, now, now.Add(-time.Hour), nil, 1},
+		{"agents failing", true, now, now.Add(-time.Hour), errors.New("all 4 Consul agent calls failed"), 1},
+		{"everything", false, now.Add(-time.Hour), now.Add(-time.Hour), errors.New("failed"), 3},
+	}
+
+	for _, tt := range tests {
+		s := checkHealth(tt.hasLeader, "Zookeeper", tt.lastSync, tt.started, maxAge, tt.err)
+		if len(s.Reasons) != tt.reasons {
+			t.Errorf("%s: reasons => %q, want %d", tt.name, s.Reasons, tt.reasons)
+		}
+		if (s.Status == "OK") != (tt.reasons == 0) {
+			t.Errorf("%s: status => %s", tt.name, s.Status)
+		}
+	}
+}
+
+func TestHealthHandler(t *testing.T) {
+	c := config.DefaultConfig()
+	h := newHealth(c)
+
+	// Not connected to Zookeeper yet
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/health", nil))
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("status code => %d, want 503", rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), `"no leading master known from Zookeeper"`) {
+		t.Errorf("body => %s", rec.Body.String())
+	}
+
+	// Not connected to the masters yet
+	c.MesosMasters = "http://10.0.0.10:5050"
+	h = newHealth(c)
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/health", nil))
+
+	if !strings.Contains(rec.Body.String(), `"no leading master known from --mesos-masters"`) {
+		t.Errorf("body => %s", rec.Body.String())
+	}
+}
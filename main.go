@@ -2,13 +2,17 @@ package main
 
 import (
 	"fmt"
-	"net/http"
+	"io/ioutil"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/mantl/mesos-consul/config"
 	"github.com/mantl/mesos-consul/consul"
+	"github.com/mantl/mesos-consul/etcd"
+	"github.com/mantl/mesos-consul/filesd"
 	"github.com/mantl/mesos-consul/mesos"
 
 	flag "github.com/ogier/pflag"
@@ -19,54 +23,191 @@ const Name = "mesos-consul"
 const Version = "0.4.0"
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "plan" {
+		os.Exit(runPlan(os.Args[2:]))
+	}
+
 	c, err := parseFlags(os.Args[1:])
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	var h *health
 	if c.Healthcheck {
-		go StartHealthcheckService(c)
+		h = newHealth(c)
+		go StartHealthcheckService(c, h)
 	}
 
-	log.Info("Using zookeeper: ", c.Zk)
+	// Connecting to Zookeeper can take a while, and SIGHUP would
+	// kill the process until it is handled
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	logSource(c)
 	leader := mesos.New(c)
+	if h != nil {
+		h.setMesos(leader)
+	}
+
+	if c.MesosStateFile != "" {
+		if err := leader.Replay(); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	go reloadOnHangup(leader, hup)
+
+	if c.Subscribe {
+		leader.Subscribe(c.Refresh)
+		return
+	}
 
 	ticker := time.NewTicker(c.Refresh)
 	leader.Refresh()
-	for _ = range ticker.C {
+	for {
+		select {
+		case <-ticker.C:
+		case <-leader.Elected():
+		}
 		leader.Refresh()
 	}
 }
 
-func StartHealthcheckService(c *config.Config) {
-	http.HandleFunc("/health", HealthHandler)
-	log.Fatal(http.ListenAndServe(fmt.Sprintf("%s:%s", c.HealthcheckIp, c.HealthcheckPort), nil))
+// logSource logs where the state of the cluster comes from
+func logSource(c *config.Config) {
+	switch {
+	case c.MesosStateFile != "":
+		log.Info("Using state files: ", c.MesosStateFile)
+	case c.MesosAgent != "":
+		log.Info("Using agent: ", c.MesosAgent)
+	case c.MesosMasters != "":
+		log.Info("Using masters: ", c.MesosMasters)
+	default:
+		log.Info("Using zookeeper: ", c.Zk)
+	}
 }
 
-func HealthHandler(w http.ResponseWriter, r *http.Request) {
-	fmt.Fprintln(w, "OK")
+// reloadOnHangup re-reads the command line and configuration file on
+// SIGHUP and swaps the filtering and tagging rules
+func reloadOnHangup(m *mesos.Mesos, hup <-chan os.Signal) {
+	for range hup {
+		log.Info("SIGHUP received. Reloading configuration")
+
+		c, err := parseFlags(os.Args[1:])
+		if err != nil {
+			log.Error("Reload failed, keeping the current configuration: ", err)
+			continue
+		}
+
+		if err := m.Reload(c); err != nil {
+			log.Error("Reload failed, keeping the current configuration: ", err)
+			continue
+		}
+
+		log.Info("Configuration reloaded")
+	}
 }
 
 func parseFlags(args []string) (*config.Config, error) {
 	var doHelp bool
 	var doVersion bool
+
+	// First pass: find the configuration file and the options given
+	// on the command line. Errors are reported by the second pass.
+	sc := config.DefaultConfig()
+	scan := newFlagSet(sc, &doHelp, &doVersion)
+	scan.Usage = func() {}
+	scan.SetOutput(ioutil.Discard)
+	scan.Parse(args)
+
+	explicit := make(map[string]bool)
+	scan.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+
 	var c = config.DefaultConfig()
+	flags := newFlagSet(c, &doHelp, &doVersion)
+
+	if sc.ConfigFile != "" {
+		err := config.LoadFile(sc.ConfigFile, func(name, value string) error {
+			if name == "config" {
+				return fmt.Errorf("configuration files can't include other files")
+			}
+			if explicit[name] {
+				// Options on the command line override the file
+				return nil
+			}
+			return flags.Set(name, value)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+
+	args = flags.Args()
+	if len(args) > 0 {
+		return nil, fmt.Errorf("extra argument(s): %q", args)
+	}
+
+	if doVersion {
+		fmt.Printf("%s v%s\n", Name, Version)
+		os.Exit(0)
+	}
+	if doHelp {
+		flags.Usage()
+		os.Exit(0)
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
+	l, _ := log.ParseLevel(strings.ToLower(c.LogLevel))
+	log.SetLevel(l)
+
+	return c, nil
+}
 
+// newFlagSet returns the command line options bound to c
+func newFlagSet(c *config.Config, doHelp, doVersion *bool) *flag.FlagSet {
 	flags := flag.NewFlagSet("mesos-consul", flag.ContinueOnError)
 	flags.Usage = func() {
 		fmt.Println(Help())
 	}
 
-	flags.BoolVar(&doHelp, "help", false, "")
-	flags.BoolVar(&doVersion, "version", false, "")
+	flags.BoolVar(doHelp, "help", false, "")
+	flags.BoolVar(doVersion, "version", false, "")
+	flags.StringVar(&c.ConfigFile, "config", "", "")
 	flags.StringVar(&c.LogLevel, "log-level", "WARN", "")
 	flags.DurationVar(&c.Refresh, "refresh", time.Minute, "")
+	flags.DurationVar(&c.Reconcile, "reconcile", 5*time.Minute, "")
+	flags.BoolVar(&c.Subscribe, "mesos-subscribe", false, "")
 	flags.StringVar(&c.Zk, "zk", "zk://127.0.0.1:2181/mesos", "")
+	flags.StringVar(&c.ZkAuth, "zk-auth", "", "")
+	flags.DurationVar(&c.ZkConnectTimeout, "zk-connect-timeout", 10*time.Second, "")
+	flags.DurationVar(&c.ZkSessionTimeout, "zk-session-timeout", 10*time.Second, "")
+	flags.StringVar(&c.MesosStateFile, "mesos-state-file", "", "")
+	flags.StringVar(&c.MesosAgent, "mesos-agent", "", "")
+	flags.StringVar(&c.MesosMasters, "mesos-masters", "", "")
+	flags.DurationVar(&c.MesosTimeout, "mesos-timeout", 30*time.Second, "")
+	flags.IntVar(&c.MesosRetries, "mesos-retries", 2, "")
+	flags.BoolVar(&c.MesosSSL, "mesos-ssl", false, "")
+	flags.StringVar(&c.MesosSSLCaCert, "mesos-ssl-cacert", "", "")
+	flags.StringVar(&c.MesosSSLCert, "mesos-ssl-cert", "", "")
+	flags.StringVar(&c.MesosSSLKey, "mesos-ssl-key", "", "")
+	flags.StringVar(&c.MesosAuth, "mesos-auth", "", "")
+	flags.StringVar(&c.MesosAuthFile, "mesos-auth-file", "", "")
 	flags.StringVar(&c.Separator, "group-separator", "", "")
 	flags.StringVar(&c.MesosIpOrder, "mesos-ip-order", "netinfo,mesos,host", "")
 	flags.BoolVar(&c.Healthcheck, "healthcheck", false, "")
 	flags.StringVar(&c.HealthcheckIp, "healthcheck-ip", "", "")
 	flags.StringVar(&c.HealthcheckPort, "healthcheck-port", "24476", "")
+	flags.IntVar(&c.HealthcheckMaxRefreshes, "healthcheck-max-refreshes", 3, "")
 	flags.Var((funcVar)(func(s string) error {
 		c.TaskWhiteList = append(c.TaskWhiteList, s)
 		return nil
@@ -91,60 +232,125 @@ func parseFlags(args []string) (*config.Config, error) {
 	flags.StringVar(&c.ServiceTags, "service-tags", "", "")
 	flags.StringVar(&c.ServiceIdPrefix, "service-id-prefix", "mesos-consul", "")
 	flags.StringVar(&c.ServicePortLabel, "service-port-label", "", "")
+	flags.StringVar(&c.ServiceMetaPrefix, "service-meta-prefix", "consul_meta_", "")
+	flags.StringVar(&c.InstanceName, "instance-name", "", "")
+	flags.StringVar(&c.CheckTimeout, "check-timeout", "", "")
+	flags.StringVar(&c.CheckHTTPMethod, "check-http-method", "", "")
+	flags.Var((funcVar)(func(s string) error {
+		c.CheckHTTPHeader = append(c.CheckHTTPHeader, s)
+		return nil
+	}), "check-http-header", "")
+	flags.BoolVar(&c.CheckTLSSkipVerify, "check-tls-skip-verify", false, "")
+	flags.BoolVar(&c.CheckGRPCUseTLS, "check-grpc-use-tls", false, "")
+	flags.StringVar(&c.CheckDeregisterAfter, "check-deregister-critical-service-after", "", "")
+	flags.DurationVar(&c.MesosHealthTTL, "mesos-health-ttl", 0, "")
+	flags.StringVar(&c.Registry, "registry", "consul", "")
+	flags.BoolVar(&c.DryRun, "dry-run", false, "")
+	flags.StringVar(&c.PlanFormat, "plan-format", "text", "")
+	flags.BoolVar(&c.HA, "ha", false, "")
+	flags.StringVar(&c.HAPath, "ha-path", "/mesos-consul", "")
+	flags.IntVar(&c.HeartbeatsBeforeRemove, "heartbeats-before-remove", 1, "")
+	flags.DurationVar(&c.DeregisterGrace, "deregister-grace", 0, "")
+	flags.IntVar(&c.DeregisterMaxPercent, "deregister-max-percent", 0, "")
+	flags.IntVar(&c.DeregisterMaxCount, "deregister-max-count", 0, "")
+	flags.IntVar(&c.DeregisterConfirmCycles, "deregister-confirm-cycles", 3, "")
+	flags.BoolVar(&c.DeregisterReleaseAPI, "deregister-release-api", false, "")
 
 	consul.AddCmdFlags(flags)
+	etcd.AddCmdFlags(flags)
+	filesd.AddCmdFlags(flags)
 
-	if err := flags.Parse(args); err != nil {
-		return nil, err
-	}
-
-	args = flags.Args()
-	if len(args) > 0 {
-		return nil, fmt.Errorf("extra argument(s): %q", args)
-	}
-
-	if doVersion {
-		fmt.Printf("%s v%s\n", Name, Version)
-		os.Exit(0)
-	}
-	if doHelp {
-		flags.Usage()
-		os.Exit(0)
-	}
-
-	l, err := log.ParseLevel(strings.ToLower(c.LogLevel))
-	if err != nil {
-		log.SetLevel(log.WarnLevel)
-		log.Warnf("Invalid log level '%v'. Setting to WARN", c.LogLevel)
-	} else {
-		log.SetLevel(l)
-	}
-
-	return c, nil
+	return flags
 }
 
 func Help() string {
 	helpText := `
 Usage: mesos-consul [options]
+       mesos-consul plan [options]
+
+The plan command compares the services mesos-consul would register with
+the services the Consul agents hold, prints the services to add, change
+and remove, and exits. The exit code is 0 when they are in sync, 2 when
+they differ and 1 on error.
 
 Options:
 
   --version 			Print mesos-consul version
+  --config=<path>		Read options from an HCL or JSON file. Keys are the option
+				names without the leading dashes. Options given on the
+				command line override the file. Send SIGHUP to reload
+				the filtering and tagging options
   --log-level=<log_level>	Set the Logging level to one of [ "DEBUG", "INFO", "WARN", "ERROR" ]
 				(default "WARN")
   --refresh=<time>		Set the Mesos refresh rate (default 1m)
+  --reconcile=<time>		Set how often the services on each Consul agent are compared
+				with the cache, re-registering missing services and removing
+				unmanaged ones. 0 disables reconciliation (default 5m)
+  --mesos-subscribe		Follow the Mesos v1 Operator API event stream instead of
+				polling state.json. Falls back to polling every refresh
+				interval while the stream is not available. (default not enabled)
   --zk=<address>		Zookeeper path to Mesos (default zk://127.0.0.1:2181/mesos)
+  --zk-auth=<user>:<password>	Digest authentication with Zookeeper, for the masters and
+				the --ha election (default: not set)
+  --zk-connect-timeout=<time>	Timeout of the connection to a Zookeeper server
+				(default 10s)
+  --zk-session-timeout=<time>	Zookeeper session timeout. Deregistrations are paused
+				while the session is lost (default 10s)
+  --mesos-agent=<host:port>	Run on a Mesos agent and register only its tasks, reading
+				/slave(1)/state of the given agent (e.g. 127.0.0.1:5051)
+				instead of the leading master. Hosts are not registered
+				and Zookeeper is not used
+  --mesos-masters=<url>,...	Find the leading master through these masters, or a load
+				balancer in front of them, instead of Zookeeper, e.g.
+				http://m1:5050,http://m2:5050. The leader comes from
+				/master/redirect, or the 'leader' field of the state
+  --mesos-timeout=<time>	Timeout of a state fetch from a master or agent. 0 disables
+				(default 30s)
+  --mesos-retries=<n>		Rounds of retries across every known master, with a
+				jittered backoff, when the state can't be fetched
+				(default 2)
+  --mesos-ssl			Use HTTPS when talking to the Mesos masters and agents,
+				and in the health checks of the registered hosts
+				(default: false)
+  --mesos-ssl-cacert=<path>	Path to a CA certificate file, containing one or more CA
+				certificates to use to validate the certificate sent
+				by the Mesos masters and agents (default: system CAs)
+  --mesos-ssl-cert=<path>	Path to an SSL client certificate to use to authenticate
+				to the Mesos masters and agents. Requires --mesos-ssl-key
+				(default: not set)
+  --mesos-ssl-key=<path>	Path to the private key of --mesos-ssl-cert
+				(default: not set)
+  --mesos-auth=<principal>[:<secret>]
+				HTTP basic authentication principal (and optional secret)
+				for the Mesos HTTP API, separated by a colon.
+				(default: not set)
+  --mesos-auth-file=<path>	Read the principal and secret from a Mesos credential file,
+				either '<principal> <secret>' or a JSON object with
+				'principal' and 'secret' (default: not set)
+  --mesos-state-file=<path>	Replay a recorded state.json, or every .json file of a
+				directory in name order, instead of asking the leading
+				master, then exit. The leader comes from the 'leader'
+				field and Zookeeper is not used
   --group-separator=<separator> Choose the group separator. Will replace _ in task names (default is empty)
   --healthcheck 		Enables a http endpoint for health checks. When this
-				flag is enabled, serves a service health status on 127.0.0.1:24476 (default not enabled)
+				flag is enabled, serves a service health status on 127.0.0.1:24476
+				and Prometheus metrics on /metrics (default not enabled)
   --healthcheck-ip=<ip> 	Health check interface ip
   --healthcheck-port=<port>	Health check service port (default 24476)
+  --healthcheck-max-refreshes=<n> Fail the health check when there was no successful
+				sync for n refresh intervals, when no leader is known or
+				when every Consul agent call failed in the last cycle
+				(default 3)
   --mesos-ip-order		Comma separated list to control the order in
 				which mesos-consul searches for the task IP
 				address. Valid options are 'netinfo', 'mesos', 'docker' and 'host'
 				(default netinfo,mesos,host)
-  --heartbeats-before-remove	Number of times that registration needs to fail before removing
-				task from Consul. (default: 1)
+  --heartbeats-before-remove	Number of refreshes a service must be missing from Mesos
+				before it is removed, unless the heartbeats_before_remove
+				label of the task sets it. (default: 1)
+  --deregister-grace=<duration>	Minimum time a service is kept after it went missing from
+				Mesos, in addition to --heartbeats-before-remove, unless
+				the deregister_grace label of the task sets it. (default 0)
   --whitelist=<regex>		Only register services matching the provided regex.
 				Can be specified multiple times
   --blacklist=<regex>		Do not register services matching the provided regex.
@@ -161,7 +367,56 @@ Options:
   --service-tags=<tag>,...	Comma delimited list of tags to add to the mesos hosts
 				Hosts are registered as
 				(leader|master|follower).<tag>.mesos.service.conul
-` + consul.Help()
+  --service-meta-prefix=<prefix>
+				Task labels starting with the prefix are added to the
+				service metadata, without the prefix. Empty disables
+				(default consul_meta_)
+  --instance-name=<name>	Name of this instance in the service metadata
+				(default the hostname)
+  --check-timeout=<duration>	Timeout of the task checks, unless the check_timeout
+				label sets it (default Consul's)
+  --check-http-method=<method>	Method of the task HTTP checks, unless the
+				check_http_method label sets it (default GET)
+  --check-http-header=<name>: <value>
+				Header sent by the task HTTP checks, in addition to the
+				check_http_header_<name> labels.
+				Can be specified multiple times
+  --check-tls-skip-verify	Don't verify the certificate of the task HTTPS checks,
+				unless the check_tls_skip_verify label says otherwise
+  --check-grpc-use-tls		Use TLS for the task gRPC checks, unless the
+				check_grpc_use_tls label says otherwise
+  --check-deregister-critical-service-after=<duration>
+				Have Consul deregister task services whose check stays
+				critical this long, unless the
+				check_deregister_critical_service_after label sets it.
+				Covers the time mesos-consul is down (default never)
+  --mesos-health-ttl=<duration>	Add a TTL check with this TTL to every task service, and
+				set it from the Mesos health of the task on every
+				refresh. Must be longer than the refresh interval.
+				0 disables (default 0)
+  --registry=<backend>,...	Comma separated list of registry backends to write services
+				to. Valid options are 'consul', 'etcd' and 'file-sd'.
+				Additional Consul clusters can be given as
+				'consul://[address][:port][?token=<token>]'
+				(default consul)
+  --dry-run			Do not register anything, just log what would have been done.
+  --plan-format=<format>	Output of the plan command, 'text' or 'json' (default text)
+  --ha				Run several instances, only one of which registers services.
+				The instances elect a leader through the Zookeeper servers
+				of --zk. A standby takes over when the leader goes away,
+				and loads its cache then (default not enabled)
+  --ha-path=<path>		Zookeeper path of the election (default /mesos-consul)
+  --deregister-max-percent=<n>	Hold the deregistrations when a refresh would remove more
+				than n percent of the managed services. 0 disables (default 0)
+  --deregister-max-count=<n>	Hold the deregistrations when a refresh would remove more
+				than n managed services. 0 disables (default 0)
+  --deregister-confirm-cycles=<n> Go ahead with held deregistrations after n consecutive
+				refreshes agree. 0 waits for POST /v1/breaker/release
+				on the health check listener (default 3)
+  --deregister-release-api	Serve POST /v1/breaker/release on the health check
+				listener. Anyone who can reach the listener can then
+				release the breaker (default not enabled)
+` + consul.Help() + etcd.Help() + filesd.Help()
 
 	return strings.TrimSpace(helpText)
 }
@@ -0,0 +1,103 @@
+package mesos
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mantl/mesos-consul/metrics"
+	"github.com/mantl/mesos-consul/registry"
+	"github.com/mantl/mesos-consul/state"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// agentState is the part of the /slave(1)/state endpoint of a Mesos
+// agent that is needed to register its tasks
+type agentState struct {
+	ID         string           `json:"id"`
+	Hostname   string           `json:"hostname"`
+	PID        state.PID        `json:"pid"`
+	Frameworks []agentFramework `json:"frameworks"`
+}
+
+type agentFramework struct {
+	ID        string          `json:"id"`
+	Name      string          `json:"name"`
+	Executors []agentExecutor `json:"executors"`
+}
+
+type agentExecutor struct {
+	Tasks []state.Task `json:"tasks"`
+}
+
+// toState()
+//   Convert the agent state to a master state with a single agent
+//
+func (as agentState) toState() state.State {
+	sj := state.State{
+		Slaves: []state.Slave{{
+			ID:       as.ID,
+			Hostname: as.Hostname,
+			PID:      as.PID,
+		}},
+	}
+
+	for _, af := range as.Frameworks {
+		fw := state.Framework{
+			ID:   af.ID,
+			Name: af.Name,
+		}
+		for _, e := range af.Executors {
+			fw.Tasks = append(fw.Tasks, e.Tasks...)
+		}
+
+		sj.Frameworks = append(sj.Frameworks, fw)
+	}
+
+	return sj
+}
+
+// loadFromAgent()
+//   Read the state of the local agent instead of the leading master
+//
+func (m *Mesos) loadFromAgent() (state.State, error) {
+	url := m.mesosAPI().url(m.agentAddr, "/slave(1)/state")
+
+	log.Info("reloading from agent ", m.agentAddr)
+
+	start := time.Now()
+
+	var as agentState
+	n, err := m.fetchJSON(url, &as)
+	if err != nil {
+		metrics.StateFetchErrors.WithLabelValues(fetchErrorKind(err)).Inc()
+		return state.State{}, err
+	}
+	if as.PID.UPID == nil {
+		return state.State{}, fmt.Errorf("%s: no agent pid", url)
+	}
+
+	metrics.StateFetchDuration.Observe(time.Since(start).Seconds())
+	metrics.StateFetchBytes.Set(float64(n))
+
+	return as.toState(), nil
+}
+
+// loadAgentCache()
+//   Populate the cache from the Consul agent of the local Mesos agent
+//   only. Loading the whole catalog would deregister the tasks of
+//   every other agent. Registries that can't load a single agent
+//   start with an empty cache.
+//
+func (m *Mesos) loadAgentCache(sj state.State) {
+	l, ok := m.Registry.(registry.AgentCacheLoader)
+	if !ok {
+		return
+	}
+
+	for _, s := range sj.Slaves {
+		if err := l.CacheLoadAgent(toIP(s.PID.Host), m.ServiceIdPrefix); err != nil {
+			log.Warn("Unable to load the cache from the local agent: ", err)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package mesos
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mantl/mesos-consul/config"
+	"github.com/mantl/mesos-consul/registry"
+	"github.com/mantl/mesos-consul/state"
+)
+
+const agentStateJSON = `{
+  "id": "S1",
+  "hostname": "agent1",
+  "pid": "slave(1)@10.0.0.1:5051",
+  "frameworks": [
+    {"id": "F1", "name": "marathon", "executors": [
+      {"tasks": [
+        {"id": "web.1", "name": "web", "slave_id": "S1", "state": "TASK_RUNNING",
+         "resources": {"ports": "[31000-31000]"}}
+      ]},
+      {"tasks": [
+        {"id": "web.2", "name": "web", "slave_id": "S1", "state": "TASK_STAGING"}
+      ]}
+    ]}
+  ]
+}`
+
+const masterStateJSON = `{
+  "leader": "master@10.0.0.10:5050",
+  "slaves": [{"id": "S1", "hostname": "agent1", "pid": "slave(1)@10.0.0.1:5051"}],
+  "frameworks": [
+    {"id": "F1", "name": "marathon", "tasks": [
+      {"id": "web.1", "name": "web", "slave_id": "S1", "state": "TASK_RUNNING",
+       "resources": {"ports": "[31000-31000]"}},
+      {"id": "web.2", "name": "web", "slave_id": "S1", "state": "TASK_STAGING"}
+    ]}
+  ]
+}`
+
+func newTestMesos(t *testing.T, r registry.Registry) *Mesos {
+	m := &Mesos{
+		Registry:        r,
+		IpOrder:         []string{"host"},
+		ServiceIdPrefix: "mesos-consul",
+	}
+	if err := m.Reload(config.DefaultConfig()); err != nil {
+		t.Fatal(err)
+	}
+
+	return m
+}
+
+func TestAgentMode(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/slave(1)/state" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, agentStateJSON)
+	}))
+	defer ts.Close()
+
+	agent := &registry.Recorder{}
+	m := newTestMesos(t, agent)
+	m.agentAddr = strings.TrimPrefix(ts.URL, "http://")
+
+	sj, err := m.loadState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.parseState(sj)
+
+	var msj state.State
+	if err := json.Unmarshal([]byte(masterStateJSON), &msj); err != nil {
+		t.Fatal(err)
+	}
+	master := &registry.Recorder{}
+	newTestMesos(t, master).parseState(msj)
+
+	// The master mode registers the agent host too
+	var want []*registry.Service
+	for _, s := range master.Services {
+		if !strings.HasPrefix(s.ID, "mesos-consul:mesos:") {
+			want = append(want, s)
+		}
+	}
+
+	if len(want) == 0 {
+		t.Fatal("no task registered in master mode")
+	}
+	if !reflect.DeepEqual(agent.Services, want) {
+		for _, s := range agent.Services {
+			t.Logf("agent:  %+v", s)
+		}
+		for _, s := range want {
+			t.Logf("master: %+v", s)
+		}
+		t.Error("agent mode registrations differ from master mode")
+	}
+}
+
+func TestNewWithoutZk(t *testing.T) {
+	c := config.DefaultConfig()
+	c.Zk = ""
+	c.MesosAgent = "10.0.0.1:5051"
+
+	m := New(c)
+	if m == nil || m.agentAddr != c.MesosAgent {
+		t.Fatalf("New() without --zk => %+v", m)
+	}
+}
@@ -0,0 +1,156 @@
+package mesos
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/mantl/mesos-consul/config"
+)
+
+// apiClient sends the requests to the HTTP API of the Mesos masters
+// and agents, with the scheme, TLS settings and credentials of the
+// cluster
+type apiClient struct {
+	scheme    string
+	client    *http.Client
+	principal string
+	secret    string
+}
+
+var defaultAPIClient = &apiClient{scheme: "http", client: &http.Client{}}
+
+// newAPIClient()
+//   Build the client from the mesos-ssl and mesos-auth options
+//
+func newAPIClient(c *config.Config) (*apiClient, error) {
+	a := &apiClient{scheme: "http", client: &http.Client{}}
+
+	if c.MesosSSL {
+		a.scheme = "https"
+
+		tlsConfig := &tls.Config{}
+
+		if c.MesosSSLCert != "" {
+			cert, err := tls.LoadX509KeyPair(c.MesosSSLCert, c.MesosSSLKey)
+			if err != nil {
+				return nil, fmt.Errorf("mesos-ssl-cert: %s", err)
+			}
+			tlsConfig.Certificates = []tls.Certificate{cert}
+		}
+
+		if c.MesosSSLCaCert != "" {
+			caCert, err := ioutil.ReadFile(c.MesosSSLCaCert)
+			if err != nil {
+				return nil, fmt.Errorf("mesos-ssl-cacert: %s", err)
+			}
+
+			caCertPool := x509.NewCertPool()
+			if !caCertPool.AppendCertsFromPEM(caCert) {
+				return nil, fmt.Errorf("mesos-ssl-cacert: no certificate in %s", c.MesosSSLCaCert)
+			}
+			tlsConfig.RootCAs = caCertPool
+		}
+
+		a.client.Transport = &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: tlsConfig,
+		}
+	}
+
+	switch {
+	case c.MesosAuth != "":
+		parts := strings.SplitN(c.MesosAuth, ":", 2)
+		a.principal = parts[0]
+		if len(parts) == 2 {
+			a.secret = parts[1]
+		}
+	case c.MesosAuthFile != "":
+		principal, secret, err := readCredential(c.MesosAuthFile)
+		if err != nil {
+			return nil, fmt.Errorf("mesos-auth-file: %s", err)
+		}
+		a.principal = principal
+		a.secret = secret
+	}
+
+	return a, nil
+}
+
+// readCredential()
+//   Read a principal and secret from a file in one of the formats
+//   of the Mesos credential files: a JSON object with principal and
+//   secret, or a single "<principal> <secret>" line
+//
+func readCredential(path string) (string, string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", "", err
+	}
+
+	text := strings.TrimSpace(string(data))
+	if strings.HasPrefix(text, "{") {
+		var cred struct {
+			Principal string `json:"principal"`
+			Secret    string `json:"secret"`
+		}
+		if err := json.Unmarshal([]byte(text), &cred); err != nil {
+			return "", "", fmt.Errorf("%s: %s", path, err)
+		}
+		if cred.Principal == "" {
+			return "", "", fmt.Errorf("%s: no principal", path)
+		}
+
+		return cred.Principal, cred.Secret, nil
+	}
+
+	fields := strings.Fields(text)
+	if len(fields) != 2 {
+		return "", "", fmt.Errorf("%s: must hold '<principal> <secret>' or a JSON credential", path)
+	}
+
+	return fields[0], fields[1], nil
+}
+
+// url()
+//   URL of path on the Mesos host at hostport
+//
+func (a *apiClient) url(hostport, path string) string {
+	return a.scheme + "://" + hostport + path
+}
+
+// newRequest()
+//   Build a request carrying the credentials, if any
+//
+func (a *apiClient) newRequest(method, url string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, err
+	}
+
+	if a.principal != "" {
+		req.SetBasicAuth(a.principal, a.secret)
+	}
+
+	return req, nil
+}
+
+func (a *apiClient) Do(req *http.Request) (*http.Response, error) {
+	return a.client.Do(req)
+}
+
+// mesosAPI()
+//   The client of the Mesos HTTP API
+//
+func (m *Mesos) mesosAPI() *apiClient {
+	if m.api == nil {
+		return defaultAPIClient
+	}
+
+	return m.api
+}
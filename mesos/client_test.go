@@ -0,0 +1,87 @@
+package mesos
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mantl/mesos-consul/config"
+	"github.com/mantl/mesos-consul/registry"
+)
+
+func TestReadCredential(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mesos-consul")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, tt := range []struct {
+		content   string
+		principal string
+		secret    string
+		ok        bool
+	}{
+		{"mesos-consul s3cret\n", "mesos-consul", "s3cret", true},
+		{`{"principal": "mesos-consul", "secret": "s3cret"}`, "mesos-consul", "s3cret", true},
+		{`{"secret": "s3cret"}`, "", "", false},
+		{"mesos-consul", "", "", false},
+	} {
+		path := filepath.Join(dir, "credential")
+		if err := ioutil.WriteFile(path, []byte(tt.content), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		principal, secret, err := readCredential(path)
+		if (err == nil) != tt.ok {
+			t.Errorf("readCredential(%q) error = %v, want ok %v", tt.content, err, tt.ok)
+			continue
+		}
+		if principal != tt.principal || secret != tt.secret {
+			t.Errorf("readCredential(%q) = %q, %q, want %q, %q", tt.content, principal, secret, tt.principal, tt.secret)
+		}
+	}
+}
+
+func TestAPIClient(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "mesos-consul" || pass != "s3cret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, agentStateJSON)
+	}))
+	defer ts.Close()
+
+	c := config.DefaultConfig()
+	c.MesosSSL = true
+	c.MesosAuth = "mesos-consul:s3cret"
+
+	api, err := newAPIClient(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := api.url("10.0.0.1:5050", "/master/health"); got != "https://10.0.0.1:5050/master/health" {
+		t.Errorf("url() = %s", got)
+	}
+	api.client = ts.Client()
+
+	agent := &registry.Recorder{}
+	m := newTestMesos(t, agent)
+	m.api = api
+	m.agentAddr = strings.TrimPrefix(ts.URL, "https://")
+
+	if _, err := m.loadFromAgent(); err != nil {
+		t.Fatal(err)
+	}
+
+	api.secret = "wrong"
+	if _, err := m.loadFromAgent(); err == nil {
+		t.Error("loadFromAgent() succeeded with the wrong secret")
+	}
+}
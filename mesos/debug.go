@@ -0,0 +1,130 @@
+package mesos
+
+import (
+	"sort"
+
+	"github.com/mantl/mesos-consul/registry"
+)
+
+// TaskDebug explains what the last sync did with a Mesos task
+type TaskDebug struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	ServiceName string `json:"service_name,omitempty"`
+	Framework   string `json:"framework"`
+	State       string `json:"state"`
+	Agent       string `json:"agent,omitempty"`
+
+	// Outcome of the framework and task filters
+	FrameworkFilter string `json:"framework_filter"`
+	TaskFilter      string `json:"task_filter,omitempty"`
+
+	Registered bool     `json:"registered"`
+	Reason     string   `json:"reason"`
+	ServiceIDs []string `json:"service_ids,omitempty"`
+}
+
+// Services()
+//   Return the services generated by the last sync, ordered by ID
+//
+func (m *Mesos) Services() []*registry.Service {
+	m.syncLock.Lock()
+	defer m.syncLock.Unlock()
+
+	services := make([]*registry.Service, len(m.services))
+	copy(services, m.services)
+	sort.Stable(servicesByID(services))
+
+	return services
+}
+
+// Cache()
+//   Return the registry cache, or nil if the registry can't list it
+//
+func (m *Mesos) Cache() []registry.CacheEntry {
+	m.syncLock.Lock()
+	defer m.syncLock.Unlock()
+
+	if d, ok := m.Registry.(registry.CacheDumper); ok {
+		return d.CacheDump()
+	}
+
+	return nil
+}
+
+// InGrace()
+//   Return the cache entries missing from Mesos but kept for their
+//   deregistration grace
+//
+func (m *Mesos) InGrace() []registry.CacheEntry {
+	entries := []registry.CacheEntry{}
+	for _, e := range m.Cache() {
+		if e.MissingSince != nil {
+			entries = append(entries, e)
+		}
+	}
+
+	return entries
+}
+
+// Tasks()
+//   Return the tasks seen by the last sync, ordered by ID
+//
+func (m *Mesos) Tasks() []*TaskDebug {
+	m.syncLock.Lock()
+	defer m.syncLock.Unlock()
+
+	ids := make([]string, 0, len(m.tasks))
+	for id := range m.tasks {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	tasks := make([]*TaskDebug, 0, len(ids))
+	for _, id := range ids {
+		tasks = append(tasks, m.tasks[id])
+	}
+
+	return tasks
+}
+
+// Task()
+//   Return what the last sync did with a task
+//
+func (m *Mesos) Task(id string) (*TaskDebug, bool) {
+	m.syncLock.Lock()
+	defer m.syncLock.Unlock()
+
+	d, ok := m.tasks[id]
+	return d, ok
+}
+
+type servicesByID []*registry.Service
+
+func (s servicesByID) Len() int           { return len(s) }
+func (s servicesByID) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s servicesByID) Less(i, j int) bool { return s[i].ID < s[j].ID }
+
+// BreakerHeld()
+//   Return the services whose deregistration is held, and whether
+//   the breaker is enabled
+//
+func (m *Mesos) BreakerHeld() ([]string, bool) {
+	if m.breaker == nil {
+		return nil, false
+	}
+
+	return m.breaker.Held(), true
+}
+
+// ReleaseBreaker()
+//   Let the held deregistrations go ahead in the next sync
+//
+func (m *Mesos) ReleaseBreaker() bool {
+	if m.breaker == nil {
+		return false
+	}
+
+	m.breaker.Release()
+	return true
+}
@@ -0,0 +1,96 @@
+package mesos
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/mantl/mesos-consul/config"
+	"github.com/mantl/mesos-consul/registry"
+	"github.com/mantl/mesos-consul/state"
+)
+
+const debugState = `{
+  "leader": "master@10.0.0.10:5050",
+  "slaves": [{"id": "S1", "hostname": "agent1", "pid": "slave(1)@10.0.0.1:5051"}],
+  "frameworks": [
+    {"name": "marathon", "tasks": [
+      {"id": "web.1", "name": "web", "slave_id": "S1", "state": "TASK_RUNNING",
+       "resources": {"ports": "[31000-31000]"}},
+      {"id": "db.1", "name": "db", "slave_id": "S1", "state": "TASK_RUNNING"},
+      {"id": "web.2", "name": "web", "slave_id": "S1", "state": "TASK_STAGING"},
+      {"id": "web.3", "name": "web", "slave_id": "S2", "state": "TASK_RUNNING"}
+    ]},
+    {"name": "chronos", "tasks": [
+      {"id": "job.1", "name": "job", "slave_id": "S1", "state": "TASK_RUNNING"}
+    ]}
+  ]
+}`
+
+func TestParseStateDebug(t *testing.T) {
+	var sj state.State
+	if err := json.Unmarshal([]byte(debugState), &sj); err != nil {
+		t.Fatal(err)
+	}
+
+	c := config.DefaultConfig()
+	c.TaskWhiteList = []string{"^db$", "^web"}
+	c.TaskBlackList = []string{"^d"}
+	c.FwBlackList = []string{"^chron"}
+
+	r := &registry.Recorder{}
+	m := &Mesos{
+		Registry:        r,
+		IpOrder:         []string{"host"},
+		ServiceIdPrefix: "mesos-consul",
+	}
+	if err := m.Reload(c); err != nil {
+		t.Fatal(err)
+	}
+
+	m.parseState(sj)
+
+	tests := []struct {
+		id         string
+		registered bool
+		reason     string
+		fwFilter   string
+		taskFilter string
+	}{
+		{"web.1", true, "registered", "no whitelist or blacklist pattern matches", "whitelist pattern '^web' matches"},
+		{"db.1", false, "task not allowed", "no whitelist or blacklist pattern matches", "whitelist pattern '^db$' matches, but blacklist pattern '^d' matches too"},
+		{"web.2", false, "task not running", "no whitelist or blacklist pattern matches", ""},
+		{"web.3", false, "unknown agent S2", "no whitelist or blacklist pattern matches", ""},
+		{"job.1", false, "framework not allowed", "blacklist pattern '^chron' matches", ""},
+	}
+
+	for _, tt := range tests {
+		d, ok := m.Task(tt.id)
+		if !ok {
+			t.Errorf("%s: not recorded", tt.id)
+			continue
+		}
+		if d.Registered != tt.registered || d.Reason != tt.reason {
+			t.Errorf("%s: registered => %v (%s), want %v (%s)", tt.id, d.Registered, d.Reason, tt.registered, tt.reason)
+		}
+		if d.FrameworkFilter != tt.fwFilter {
+			t.Errorf("%s: framework filter => %q, want %q", tt.id, d.FrameworkFilter, tt.fwFilter)
+		}
+		if d.TaskFilter != tt.taskFilter {
+			t.Errorf("%s: task filter => %q, want %q", tt.id, d.TaskFilter, tt.taskFilter)
+		}
+	}
+
+	d, _ := m.Task("web.1")
+	want := []string{"mesos-consul:10.0.0.1:web:10.0.0.1:31000"}
+	if !sliceEq(d.ServiceIDs, want) {
+		t.Errorf("web.1 service IDs => %q, want %q", d.ServiceIDs, want)
+	}
+
+	// The agent service and web.1
+	if len(m.Services()) != 2 || len(r.Services) != 2 {
+		t.Errorf("services => %d, registered => %d, want 2", len(m.Services()), len(r.Services))
+	}
+	if len(m.Tasks()) != 5 {
+		t.Errorf("tasks => %d, want 5", len(m.Tasks()))
+	}
+}
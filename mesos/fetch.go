@@ -0,0 +1,237 @@
+package mesos
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mantl/mesos-consul/metrics"
+	"github.com/mantl/mesos-consul/state"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Delay before the first retry of a failed state fetch. It doubles
+// with every round, plus up to as much jitter.
+const fetchBackoff = time.Second
+
+// FetchErrorKind tells which step of a fetch failed
+type FetchErrorKind string
+
+const (
+	// The request couldn't be sent or the response couldn't be read
+	FetchNetwork FetchErrorKind = "network"
+
+	// The server answered with another status than 200 OK
+	FetchStatus FetchErrorKind = "status"
+
+	// The body isn't the expected JSON
+	FetchDecode FetchErrorKind = "decode"
+)
+
+// FetchError is a failed request to the HTTP API of a master or agent
+type FetchError struct {
+	Kind       FetchErrorKind
+	URL        string
+	StatusCode int
+	Err        error
+}
+
+func (e *FetchError) Error() string {
+	if e.Kind == FetchStatus {
+		return fmt.Sprintf("%s: HTTP status %d", e.URL, e.StatusCode)
+	}
+
+	return fmt.Sprintf("%s: %s error: %s", e.URL, e.Kind, e.Err)
+}
+
+// retryable()
+//   Return false when asking again, or asking another master, would
+//   fail the same way
+//
+func (e *FetchError) retryable() bool {
+	if e.Kind != FetchStatus {
+		return true
+	}
+
+	return e.StatusCode != http.StatusUnauthorized && e.StatusCode != http.StatusForbidden
+}
+
+// countingReader counts the bytes read from the network, and keeps
+// the read error apart from the decoding errors
+type countingReader struct {
+	r   io.Reader
+	n   int64
+	err error
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	if err != nil && err != io.EOF {
+		c.err = err
+	}
+
+	return n, err
+}
+
+// fetchJSON()
+//   GET url and decode the JSON body into v as it arrives. Returns
+//   the number of bytes received.
+//
+func (m *Mesos) fetchJSON(url string, v interface{}) (int64, error) {
+	api := m.mesosAPI()
+
+	ctx := context.Background()
+	if m.fetchTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, m.fetchTimeout)
+		defer cancel()
+	}
+
+	req, err := api.newRequest("GET", url, nil)
+	if err != nil {
+		return 0, &FetchError{Kind: FetchNetwork, URL: url, Err: err}
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	resp, err := api.Do(req.WithContext(ctx))
+	if err != nil {
+		return 0, &FetchError{Kind: FetchNetwork, URL: url, Err: err}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, &FetchError{Kind: FetchStatus, URL: url, StatusCode: resp.StatusCode, Err: errors.New(resp.Status)}
+	}
+
+	cr := &countingReader{r: resp.Body}
+	var body io.Reader = cr
+	if strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") {
+		gz, err := gzip.NewReader(cr)
+		if err != nil {
+			return cr.n, fetchReadError(url, cr, err)
+		}
+		defer gz.Close()
+		body = gz
+	}
+
+	if err := json.NewDecoder(body).Decode(v); err != nil {
+		return cr.n, fetchReadError(url, cr, err)
+	}
+
+	return cr.n, nil
+}
+
+// fetchReadError()
+//   Tell a body that couldn't be received from a body that isn't JSON
+//
+func fetchReadError(url string, cr *countingReader, err error) error {
+	if cr.err != nil {
+		return &FetchError{Kind: FetchNetwork, URL: url, Err: cr.err}
+	}
+
+	return &FetchError{Kind: FetchDecode, URL: url, Err: err}
+}
+
+// fetchErrorKind()
+//   Kind of err for the metrics
+//
+func fetchErrorKind(err error) string {
+	if fe, ok := err.(*FetchError); ok {
+		return string(fe.Kind)
+	}
+
+	return "other"
+}
+
+// loadFromMasters()
+//   Fetch the state from the leader, falling back to the other known
+//   masters, and retry every master with a jittered backoff
+//
+func (m *Mesos) loadFromMasters(leader *MesosHost) (state.State, error) {
+	var lastErr error
+
+	for round := 0; round <= m.fetchRetries; round++ {
+		if round > 0 {
+			d := m.backoff(round)
+			log.Warnf("Unable to fetch the state from any master, retrying in %s: %s", d, lastErr)
+			time.Sleep(d)
+		}
+
+		for _, mh := range m.fetchOrder(leader) {
+			sj, err := m.loadFromMaster(mh.Ip, mh.PortString)
+			if err == nil {
+				if rip := leaderIP(sj.Leader); rip != "" && rip != mh.Ip {
+					log.Warn("master changed to ", rip)
+					sj, err = m.loadFromMaster(rip, mh.PortString)
+				}
+			}
+			if err == nil {
+				return sj, nil
+			}
+
+			log.Warn("Unable to fetch the state: ", err)
+			lastErr = err
+			if fe, ok := err.(*FetchError); ok && !fe.retryable() {
+				return state.State{}, err
+			}
+		}
+	}
+
+	return state.State{}, lastErr
+}
+
+// fetchOrder()
+//   The leader, then the other known masters
+//
+func (m *Mesos) fetchOrder(leader *MesosHost) []*MesosHost {
+	hosts := []*MesosHost{leader}
+	for _, mh := range m.getMasters() {
+		if mh.Ip == "" || (mh.Ip == leader.Ip && mh.PortString == leader.PortString) {
+			continue
+		}
+		hosts = append(hosts, mh)
+	}
+
+	return hosts
+}
+
+// backoff()
+//   Delay before the given retry round
+//
+func (m *Mesos) backoff(round int) time.Duration {
+	d := m.fetchBackoff << uint(round-1)
+	if d <= 0 {
+		return 0
+	}
+
+	return d + time.Duration(rand.Int63n(int64(d)))
+}
+
+func (m *Mesos) loadFromMaster(ip string, port string) (state.State, error) {
+	var sj state.State
+
+	url := m.mesosAPI().url(ip+":"+port, "/master/state.json")
+
+	start := time.Now()
+
+	n, err := m.fetchJSON(url, &sj)
+	if err != nil {
+		metrics.StateFetchErrors.WithLabelValues(fetchErrorKind(err)).Inc()
+		return sj, err
+	}
+
+	metrics.StateFetchDuration.Observe(time.Since(start).Seconds())
+	metrics.StateFetchBytes.Set(float64(n))
+
+	return sj, nil
+}
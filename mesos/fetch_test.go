@@ -0,0 +1,175 @@
+package mesos
+
+import (
+	"compress/gzip"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mantl/mesos-consul/registry"
+
+	proto "github.com/mesos/mesos-go/mesosproto"
+)
+
+func TestFetchJSON(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/gzip":
+			if r.Header.Get("Accept-Encoding") != "gzip" {
+				t.Errorf("Accept-Encoding = %q", r.Header.Get("Accept-Encoding"))
+			}
+			w.Header().Set("Content-Encoding", "gzip")
+			gz := gzip.NewWriter(w)
+			fmt.Fprint(gz, masterStateJSON)
+			gz.Close()
+		case "/plain":
+			fmt.Fprint(w, masterStateJSON)
+		case "/truncated":
+			fmt.Fprint(w, masterStateJSON[:100])
+		default:
+			http.Error(w, "no leader", http.StatusServiceUnavailable)
+		}
+	}))
+	defer ts.Close()
+
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	m := newTestMesos(t, &registry.Recorder{})
+
+	for _, url := range []string{ts.URL + "/gzip", ts.URL + "/plain"} {
+		var sj struct {
+			Leader string `json:"leader"`
+		}
+		if _, err := m.fetchJSON(url, &sj); err != nil {
+			t.Errorf("%s: %s", url, err)
+		} else if sj.Leader != "master@10.0.0.10:5050" {
+			t.Errorf("%s: leader = %q", url, sj.Leader)
+		}
+	}
+
+	for _, tt := range []struct {
+		url  string
+		kind FetchErrorKind
+	}{
+		{ts.URL + "/truncated", FetchDecode},
+		{ts.URL + "/redirect", FetchStatus},
+		{closed.URL, FetchNetwork},
+	} {
+		var v interface{}
+		_, err := m.fetchJSON(tt.url, &v)
+		fe, ok := err.(*FetchError)
+		if !ok {
+			t.Errorf("%s: error %v, want a FetchError", tt.url, err)
+			continue
+		}
+		if fe.Kind != tt.kind {
+			t.Errorf("%s: kind %s, want %s", tt.url, fe.Kind, tt.kind)
+		}
+	}
+}
+
+func TestFetchTimeout(t *testing.T) {
+	done := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-done
+	}))
+	defer ts.Close()
+	defer close(done)
+
+	m := newTestMesos(t, &registry.Recorder{})
+	m.fetchTimeout = 50 * time.Millisecond
+
+	var v interface{}
+	_, err := m.fetchJSON(ts.URL, &v)
+	if fe, ok := err.(*FetchError); !ok || fe.Kind != FetchNetwork {
+		t.Errorf("error %v, want a network FetchError", err)
+	}
+}
+
+// testMaster serves the master state after failing the given number
+// of times with status
+func testMaster(failures, status int) (*httptest.Server, *int) {
+	hits := new(int)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*hits++
+		if *hits <= failures {
+			w.WriteHeader(status)
+			return
+		}
+		fmt.Fprint(w, strings.Replace(masterStateJSON, "10.0.0.10:5050", r.Host, 1))
+	}))
+
+	return ts, hits
+}
+
+func testMasterInfo(t *testing.T, ts *httptest.Server) *proto.MasterInfo {
+	host, port, err := net.SplitHostPort(strings.TrimPrefix(ts.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, _ := strconv.Atoi(port)
+
+	return newMasterInfo(host, p)
+}
+
+func TestLoadFromMasters(t *testing.T) {
+	leader, leaderHits := testMaster(100, http.StatusServiceUnavailable)
+	defer leader.Close()
+	standby, _ := testMaster(0, 0)
+	defer standby.Close()
+
+	m := newTestMesos(t, &registry.Recorder{})
+	m.Leader = testMasterInfo(t, leader)
+	m.Masters = []*proto.MasterInfo{m.Leader, testMasterInfo(t, standby)}
+
+	sj, err := m.loadFromMasters(m.getLeader())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sj.Frameworks) != 1 {
+		t.Errorf("got %d frameworks, want 1", len(sj.Frameworks))
+	}
+	if *leaderHits != 1 {
+		t.Errorf("leader asked %d times, want 1", *leaderHits)
+	}
+}
+
+func TestLoadFromMastersRetry(t *testing.T) {
+	flaky, hits := testMaster(2, http.StatusInternalServerError)
+	defer flaky.Close()
+
+	m := newTestMesos(t, &registry.Recorder{})
+	m.Leader = testMasterInfo(t, flaky)
+	m.Masters = []*proto.MasterInfo{m.Leader}
+	m.fetchBackoff = time.Millisecond
+
+	m.fetchRetries = 1
+	if _, err := m.loadFromMasters(m.getLeader()); err == nil {
+		t.Error("loadFromMasters() succeeded after 2 failures with 1 retry")
+	}
+
+	*hits = 0
+	m.fetchRetries = 2
+	if _, err := m.loadFromMasters(m.getLeader()); err != nil {
+		t.Errorf("loadFromMasters() failed after 2 failures with 2 retries: %s", err)
+	}
+
+	denied, deniedHits := testMaster(100, http.StatusUnauthorized)
+	defer denied.Close()
+	m.Leader = testMasterInfo(t, denied)
+	m.Masters = []*proto.MasterInfo{m.Leader}
+
+	_, err := m.loadFromMasters(m.getLeader())
+	if fe, ok := err.(*FetchError); !ok || fe.StatusCode != http.StatusUnauthorized {
+		t.Errorf("error %v, want a 401 FetchError", err)
+	}
+	if *deniedHits != 1 {
+		t.Errorf("unauthorized request retried %d times", *deniedHits-1)
+	}
+}
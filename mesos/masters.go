@@ -0,0 +1,196 @@
+package mesos
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/mesos/mesos-go/upid"
+
+	proto "github.com/mesos/mesos-go/mesosproto"
+	log "github.com/sirupsen/logrus"
+)
+
+// masterURLs()
+//   Parse the comma separated --mesos-masters list
+//
+func masterURLs(masters string) ([]*url.URL, error) {
+	var urls []*url.URL
+	for _, s := range strings.Split(masters, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+
+		u, err := url.Parse(s)
+		if err != nil {
+			return nil, err
+		}
+		if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("'%s' must be http://<host>:<port> or https://<host>:<port>", s)
+		}
+		urls = append(urls, u)
+	}
+
+	return urls, nil
+}
+
+// detectLeader()
+//   Find the leading master through the configured masters, in
+//   place of Zookeeper, and update Leader and Masters
+//
+func (m *Mesos) detectLeader() error {
+	var lastErr error
+
+	for _, u := range m.masterURLs {
+		host, port, err := m.askLeader(u)
+		if err != nil {
+			log.WithField("master", u.String()).Debug("No leader from master: ", err)
+			lastErr = err
+			continue
+		}
+
+		m.setMasters(host, port)
+		return nil
+	}
+
+	if lastErr == nil {
+		lastErr = fmt.Errorf("no master configured")
+	}
+
+	return fmt.Errorf("no leader found through --mesos-masters: %s", lastErr)
+}
+
+// askLeader()
+//   Ask a master, or a load balancer in front of the masters, for
+//   the address of the leader. /master/redirect answers with a
+//   redirect to the leader. Masters that don't serve it are asked
+//   for the leader field of their state.
+//
+func (m *Mesos) askLeader(u *url.URL) (string, int, error) {
+	host, port, err := m.redirectLeader(u)
+	if err == nil {
+		return host, port, nil
+	}
+	log.WithField("master", u.String()).Debug("/master/redirect failed, reading state: ", err)
+
+	return m.stateLeader(u)
+}
+
+func (m *Mesos) redirectLeader(u *url.URL) (string, int, error) {
+	api := m.mesosAPI()
+
+	req, err := api.newRequest("GET", strings.TrimRight(u.String(), "/")+"/master/redirect", nil)
+	if err != nil {
+		return "", 0, err
+	}
+
+	// The redirect is the answer, don't follow it
+	client := *api.client
+	client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", 0, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 300 || resp.StatusCode > 399 {
+		return "", 0, fmt.Errorf("/master/redirect returned %s", resp.Status)
+	}
+
+	// The location has no scheme: //<leader host>:<port>
+	loc, err := u.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		return "", 0, err
+	}
+
+	return splitHostPort(loc.Host)
+}
+
+func (m *Mesos) stateLeader(u *url.URL) (string, int, error) {
+	var sj struct {
+		Leader string `json:"leader"`
+	}
+	if _, err := m.fetchJSON(strings.TrimRight(u.String(), "/")+"/master/state", &sj); err != nil {
+		return "", 0, err
+	}
+	if sj.Leader == "" {
+		return "", 0, fmt.Errorf("no leader in state")
+	}
+
+	pid, err := upid.Parse(sj.Leader)
+	if err != nil {
+		return "", 0, err
+	}
+
+	return splitHostPort(pid.Host + ":" + pid.Port)
+}
+
+// setMasters()
+//   Record the leader at host:port, along with the configured
+//   masters, the way the Zookeeper detector does
+//
+func (m *Mesos) setMasters(host string, port int) {
+	leader := newMasterInfo(host, port)
+	masters := []*proto.MasterInfo{leader}
+
+	for _, u := range m.masterURLs {
+		h, p, err := splitHostPort(u.Host)
+		if err != nil {
+			continue
+		}
+
+		mi := newMasterInfo(h, p)
+		if mi.GetId() != leader.GetId() {
+			masters = append(masters, mi)
+		}
+	}
+
+	m.updateMasters(leader, masters)
+}
+
+// newMasterInfo()
+//   MasterInfo of the master at host:port. The ID is the address
+//   of the master, which tells the leader apart from the others.
+//
+func newMasterInfo(host string, port int) *proto.MasterInfo {
+	ip := toIP(host)
+	id := fmt.Sprintf("%s:%d", ip, port)
+	p32 := int32(port)
+
+	return &proto.MasterInfo{
+		Id: &id,
+		Address: &proto.Address{
+			Hostname: &host,
+			Ip:       &ip,
+			Port:     &p32,
+		},
+	}
+}
+
+// splitHostPort()
+//   Split host:port, the port defaulting to the one of the masters
+//
+func splitHostPort(hostport string) (string, int, error) {
+	if !strings.Contains(hostport, ":") {
+		return hostport, 5050, nil
+	}
+
+	host, portString, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return "", 0, err
+	}
+
+	port, err := strconv.Atoi(portString)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid port '%s'", portString)
+	}
+
+	return host, port, nil
+}
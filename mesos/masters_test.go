@@ -0,0 +1,95 @@
+package mesos
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/mantl/mesos-consul/registry"
+)
+
+func TestDetectLeader(t *testing.T) {
+	leader := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.NotFound(w, r)
+	}))
+	defer leader.Close()
+	leaderHost := strings.TrimPrefix(leader.URL, "http://")
+
+	redirect := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/master/redirect" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Location", "//"+leaderHost)
+		w.WriteHeader(http.StatusTemporaryRedirect)
+	}))
+	defer redirect.Close()
+
+	state := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/master/state" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintf(w, `{"leader": "master@%s"}`, leaderHost)
+	}))
+	defer state.Close()
+
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+
+	for _, tt := range []struct {
+		name    string
+		masters []string
+	}{
+		{"redirect", []string{down.URL, redirect.URL}},
+		{"state", []string{down.URL, state.URL}},
+	} {
+		m := newTestMesos(t, &registry.Recorder{})
+		m.startChan = make(chan struct{})
+		m.leaderChan = make(chan struct{}, 1)
+		urls, err := masterURLs(strings.Join(tt.masters, ","))
+		if err != nil {
+			t.Fatal(err)
+		}
+		m.masterURLs = urls
+
+		if err := m.detectLeader(); err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+			continue
+		}
+
+		mh := m.getLeader()
+		if got := mh.Ip + ":" + mh.PortString; got != leaderHost {
+			t.Errorf("%s: leader = %s, want %s", tt.name, got, leaderHost)
+		}
+
+		masters := m.getMasters()
+		if len(masters) != 3 {
+			t.Errorf("%s: got %d masters, want 3", tt.name, len(masters))
+			continue
+		}
+		for i, ma := range masters {
+			if ma.IsLeader != (i == 0) {
+				t.Errorf("%s: master %s:%s IsLeader = %v", tt.name, ma.Ip, ma.PortString, ma.IsLeader)
+			}
+		}
+
+		select {
+		case <-m.leaderChan:
+		default:
+			t.Errorf("%s: leader change not signaled", tt.name)
+		}
+	}
+
+	m := newTestMesos(t, &registry.Recorder{})
+	u, _ := url.Parse(down.URL)
+	m.masterURLs = []*url.URL{u}
+	if err := m.detectLeader(); err == nil {
+		t.Error("detectLeader() found a leader through a master without one")
+	}
+}
@@ -1,15 +1,21 @@
 package mesos
 
 import (
-	"encoding/json"
 	"errors"
-	"io/ioutil"
+	"fmt"
 	"net/http"
+	"net/url"
+	"os"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/mantl/mesos-consul/config"
 	"github.com/mantl/mesos-consul/consul"
+	"github.com/mantl/mesos-consul/etcd"
+	"github.com/mantl/mesos-consul/filesd"
+	"github.com/mantl/mesos-consul/ha"
+	"github.com/mantl/mesos-consul/metrics"
 	"github.com/mantl/mesos-consul/registry"
 	"github.com/mantl/mesos-consul/state"
 
@@ -17,16 +23,40 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
+// elector is the part of the leader election the syncs use
+type elector interface {
+	IsLeader() bool
+	Role() string
+}
+
 type Mesos struct {
 	Registry registry.Registry
+	breaker  *registry.Breaker
+	election elector
 	Agents   map[string]string
 	Lock     sync.Mutex
 
+	// Whether this instance was the leader in the last sync, and
+	// whether the standby syncs keep the cache warm since.
+	// Guarded by syncLock.
+	leading bool
+	warm    bool
+
+	// Signaled when this instance is elected, to sync at once
+	electedChan chan struct{}
+
 	Leader    *proto.MasterInfo
 	Masters   []*proto.MasterInfo
 	started   sync.Once
 	startChan chan struct{}
 
+	// Signaled when the leading master changes
+	leaderChan chan struct{}
+
+	// Held while syncing, so that Reload() never swaps the
+	// rules in the middle of a sync
+	syncLock sync.Mutex
+
 	IpOrder []string
 	taskTag map[string][]string
 
@@ -40,34 +70,160 @@ type Mesos struct {
 	ServiceTags      []string
 	ServiceIdPrefix  string
 	ServicePortLabel string
+
+	// Labels with this prefix become service metadata
+	ServiceMetaPrefix string
+
+	// Check options used when the task labels don't set them
+	CheckDefaults *registry.Check
+
+	// Deregistration grace of the services, when the task labels
+	// don't set it. Guarded by syncLock.
+	grace registry.Grace
+
+	// TTL of the check following the Mesos health of the tasks,
+	// 0 when disabled
+	HealthTTL time.Duration
+
+	// Name of this mesos-consul instance, in the metadata of
+	// every task service
+	instance string
+
+	// Agent hostnames by agent ID, set by RegisterHosts
+	agentHostnames map[string]string
+
+	ReconcileInterval time.Duration
+	lastReconcile     time.Time
+
+	// Time of the last successful sync, or of the last heartbeat of
+	// the event stream that found nothing to sync. Guarded by Lock.
+	lastSync time.Time
+
+	// What the last sync generated, for the debug API.
+	// Guarded by syncLock.
+	services []*registry.Service
+	tasks    map[string]*TaskDebug
+
+	// State snapshots read instead of the leading master
+	stateFiles []string
+	stateIndex int
+
+	// Address of the local agent in agent mode, which registers
+	// the tasks of that agent only
+	agentAddr string
+
+	// Client of the HTTP API of the masters and agents
+	api *apiClient
+
+	// Masters asked for the leader in place of Zookeeper
+	masterURLs []*url.URL
+
+	// Why the masters from Zookeeper can't be trusted, empty when
+	// they can. Guarded by Lock.
+	zkDegraded string
+
+	// Whether the masters weren't read from Zookeeper yet. Guarded
+	// by Lock.
+	zkConnecting bool
+
+	// Timeout of every state fetch, 0 for none, and the rounds of
+	// retries across the masters
+	fetchTimeout time.Duration
+	fetchRetries int
+	fetchBackoff time.Duration
 }
 
 func New(c *config.Config) *Mesos {
 	m := new(Mesos)
 
-	if c.Zk == "" {
-		return nil
+	if err := m.Reload(c); err != nil {
+		log.WithField("task-tag", c.TaskTag).Fatal(err.Error())
 	}
-	m.Separator = c.Separator
 
-	m.TaskPrivilege = NewPrivilege(c.TaskWhiteList, c.TaskBlackList)
-	m.FwPrivilege = NewPrivilege(c.FwWhiteList, c.FwBlackList)
+	names := strings.Split(c.Registry, ",")
+	multi := registry.NewMulti()
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		r, err := newRegistry(name, c.DryRun)
+		if err != nil {
+			if len(names) == 1 {
+				log.Fatal(err)
+			}
 
-	var err error
-	m.taskTag, err = buildTaskTag(c.TaskTag)
-	if err != nil {
-		log.WithField("task-tag", c.TaskTag).Fatal(err.Error())
+			// Keep the backends that started
+			log.WithField("registry", name).Error("Unable to start the registry, leaving it out: ", err)
+			continue
+		}
+
+		if len(names) == 1 {
+			m.Registry = r
+		} else {
+			multi.Add(name, r)
+		}
 	}
 
-	m.ServiceName = cleanName(c.ServiceName, c.Separator)
+	if m.Registry == nil {
+		if multi.Len() == 0 {
+			log.Fatal("No registry started")
+		}
+		m.Registry = multi
+	}
 
-	m.Registry = consul.New()
+	m.instance = c.InstanceName
+	if m.instance == "" {
+		m.instance, _ = os.Hostname()
+	}
 
-	if m.Registry == nil {
-		log.Fatal("No registry specified")
+	if c.DeregisterMaxPercent > 0 || c.DeregisterMaxCount > 0 {
+		m.breaker = registry.NewBreaker(m.Registry, c.DeregisterMaxPercent, c.DeregisterMaxCount, c.DeregisterConfirmCycles)
+		m.Registry = m.breaker
+	}
+
+	api, err := newAPIClient(c)
+	if err != nil {
+		log.Fatal(err)
 	}
+	m.api = api
+	m.fetchTimeout = c.MesosTimeout
+	m.fetchRetries = c.MesosRetries
+	m.fetchBackoff = fetchBackoff
+
+	if c.MesosStateFile != "" {
+		files, err := stateFiles(c.MesosStateFile)
+		if err != nil {
+			log.Fatal("mesos-state-file: ", err)
+		}
+		m.stateFiles = files
+	} else if c.MesosAgent != "" {
+		m.agentAddr = c.MesosAgent
+	} else {
+		if c.MesosMasters != "" {
+			urls, err := masterURLs(c.MesosMasters)
+			if err != nil {
+				log.Fatal("mesos-masters: ", err)
+			}
+			m.masterURLs = urls
+			m.startChan = make(chan struct{})
+			m.leaderChan = make(chan struct{}, 1)
 
-	m.zkDetector(c.Zk)
+			if err := m.detectLeader(); err != nil {
+				log.Warn(err)
+			}
+		} else {
+			m.zkDetector(c)
+		}
+
+		if c.HA {
+			e, err := ha.New(c.Zk, c.HAPath, c.ZkAuth, c.ZkConnectTimeout, c.ZkSessionTimeout)
+			if err != nil {
+				log.Fatal("ha: ", err)
+			}
+			m.electedChan = make(chan struct{}, 1)
+			e.OnElected = m.elected
+			m.election = e
+			go e.Run()
+		}
+	}
 
 	m.IpOrder = strings.Split(c.MesosIpOrder, ",")
 	for _, src := range m.IpOrder {
@@ -79,16 +235,116 @@ func New(c *config.Config) *Mesos {
 	}
 	log.Debugf("m.IpOrder = '%v'", m.IpOrder)
 
-	if c.ServiceTags != "" {
-		m.ServiceTags = strings.Split(c.ServiceTags, ",")
-	}
-
 	m.ServiceIdPrefix = c.ServiceIdPrefix
 	m.ServicePortLabel = c.ServicePortLabel
+	m.ServiceMetaPrefix = c.ServiceMetaPrefix
+	m.HealthTTL = c.MesosHealthTTL
+	m.ReconcileInterval = c.Reconcile
 
 	return m
 }
 
+// Reload()
+//   Swap the filtering and tagging rules for the ones in c. The
+//   next sync registers the tasks that are now allowed, and the
+//   tasks that are now denied get deregistered.
+//
+func (m *Mesos) Reload(c *config.Config) error {
+	taskTag, err := buildTaskTag(c.TaskTag)
+	if err != nil {
+		return err
+	}
+
+	var serviceTags []string
+	if c.ServiceTags != "" {
+		serviceTags = strings.Split(c.ServiceTags, ",")
+	}
+
+	checkDefaults, err := buildCheckDefaults(c)
+	if err != nil {
+		return err
+	}
+
+	taskPrivilege := NewPrivilege(c.TaskWhiteList, c.TaskBlackList)
+	fwPrivilege := NewPrivilege(c.FwWhiteList, c.FwBlackList)
+
+	m.syncLock.Lock()
+	defer m.syncLock.Unlock()
+
+	m.TaskPrivilege = taskPrivilege
+	m.FwPrivilege = fwPrivilege
+	m.taskTag = taskTag
+	m.ServiceTags = serviceTags
+	m.Separator = c.Separator
+	m.ServiceName = cleanName(c.ServiceName, c.Separator)
+	m.CheckDefaults = checkDefaults
+
+	m.grace = registry.Grace{
+		Cycles:   c.HeartbeatsBeforeRemove,
+		Duration: c.DeregisterGrace,
+	}
+
+	return nil
+}
+
+// buildCheckDefaults()
+//   Build the check options used when the task labels don't set them
+//
+func buildCheckDefaults(c *config.Config) (*registry.Check, error) {
+	d := registry.DefaultCheck()
+	d.Timeout = c.CheckTimeout
+	d.Method = strings.ToUpper(c.CheckHTTPMethod)
+	d.TLSSkipVerify = c.CheckTLSSkipVerify
+	d.GRPCUseTLS = c.CheckGRPCUseTLS
+	d.DeregisterCriticalServiceAfter = c.CheckDeregisterAfter
+
+	for _, h := range c.CheckHTTPHeader {
+		parts := strings.SplitN(h, ":", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("check-http-header '%s' must be <name>: <value>", h)
+		}
+		if d.Header == nil {
+			d.Header = make(map[string][]string)
+		}
+
+		name := http.CanonicalHeaderKey(strings.TrimSpace(parts[0]))
+		d.Header[name] = append(d.Header[name], strings.TrimSpace(parts[1]))
+	}
+
+	return d, nil
+}
+
+// newRegistry returns the registry backend with the given name.
+// Additional Consul clusters are given as consul://[address][:port].
+func newRegistry(name string, dryRun bool) (registry.Registry, error) {
+	if strings.HasPrefix(name, "consul://") {
+		r, err := consul.NewFromURL(name, dryRun)
+		if err != nil {
+			return nil, fmt.Errorf("consul: %s", err)
+		}
+		return r, nil
+	}
+
+	switch name {
+	case "consul":
+		return consul.New(dryRun), nil
+	case "etcd":
+		r, err := etcd.New(dryRun)
+		if err != nil {
+			return nil, fmt.Errorf("etcd: %s", err)
+		}
+		return r, nil
+	case "file-sd":
+		r, err := filesd.New(dryRun)
+		if err != nil {
+			return nil, fmt.Errorf("file-sd: %s", err)
+		}
+		return r, nil
+	}
+
+	return nil, fmt.Errorf("Invalid registry: '%v'", name)
+}
+
 // buildTaskTag takes a slice of task-tag arguments from the command line
 // and returns a map of tasks name patterns to slice of tags that should be applied.
 func buildTaskTag(taskTag []string) (map[string][]string, error) {
@@ -115,36 +371,239 @@ func buildTaskTag(taskTag []string) (map[string][]string, error) {
 }
 
 func (m *Mesos) Refresh() error {
+	start := time.Now()
+
 	sj, err := m.loadState()
 	if err != nil {
 		log.Warn("loadState failed: ", err.Error())
+		metrics.RefreshDuration.WithLabelValues(metrics.OutcomeFetchError).Observe(time.Since(start).Seconds())
 		return err
 	}
 
-	if sj.Leader == "" {
+	if sj.Leader == "" && m.agentAddr == "" {
+		metrics.RefreshDuration.WithLabelValues(metrics.OutcomeNoLeader).Observe(time.Since(start).Seconds())
 		return errors.New("Empty master")
 	}
 
-	if m.Registry.CacheCreate() {
-		m.LoadCache()
+	m.syncState(sj)
+
+	metrics.RefreshDuration.WithLabelValues(metrics.OutcomeSuccess).Observe(time.Since(start).Seconds())
+
+	return nil
+}
+
+// syncState()
+//   Bring the registry in line with the given state
+//
+func (m *Mesos) syncState(sj state.State) {
+	m.syncLock.Lock()
+	defer m.syncLock.Unlock()
+
+	if m.election != nil && !m.election.IsLeader() {
+		m.leading = false
+		m.standbySync(sj)
+		return
+	}
+
+	load := m.Registry.CacheCreate()
+	if m.election != nil && !m.leading {
+		// Just elected. Unless the standby syncs kept the cache
+		// warm, the previous leader changed the registry since this
+		// instance last held the cache.
+		m.leading = true
+		if !load && !m.warm {
+			m.clearCache()
+			load = true
+		}
+	}
+	m.warm = false
+
+	if load {
+		if m.agentAddr != "" {
+			m.loadAgentCache(sj)
+		} else {
+			m.LoadCache()
+		}
 	}
 
 	m.parseState(sj)
+	m.reconcile()
+
+	metrics.Synced()
+
+	m.Lock.Lock()
+	m.lastSync = time.Now()
+	m.Lock.Unlock()
+}
+
+// streamAlive()
+//   Count a heartbeat of the event stream as a sync. Nothing changed
+//   since the last sync, so the registry is still in line with Mesos.
+//
+func (m *Mesos) streamAlive() {
+	metrics.Synced()
+
+	m.Lock.Lock()
+	m.lastSync = time.Now()
+	m.Lock.Unlock()
+}
+
+// LastSync()
+//   Return the time of the last successful sync, or the zero time
+//   before the first one
+//
+func (m *Mesos) LastSync() time.Time {
+	m.Lock.Lock()
+	defer m.Lock.Unlock()
+
+	return m.lastSync
+}
+
+// Role()
+//   Return "leader" or "standby" when running with --ha, or an
+//   empty string
+//
+func (m *Mesos) Role() string {
+	if m.election == nil {
+		return ""
+	}
+
+	return m.election.Role()
+}
+
+// HasLeader()
+//   Return true if Zookeeper knows a leading master. Agent mode
+//   doesn't need one.
+//
+func (m *Mesos) HasLeader() bool {
+	return m.agentAddr != "" || m.getLeader().Ip != ""
+}
+
+// RegistryError()
+//   Return the error of the last registry cycle, if the registry
+//   reports one
+//
+func (m *Mesos) RegistryError() error {
+	if r, ok := m.Registry.(registry.CycleReporter); ok {
+		return r.CycleError()
+	}
 
 	return nil
 }
 
+// standbySync()
+//   Keep the debug information and the cache current without writing
+//   to the registry, while another instance is the leader
+//
+func (m *Mesos) standbySync(sj state.State) {
+	log.Info("Standing by, not registering")
+
+	services, tasks := m.desiredServices(sj)
+	setTaskMetrics(tasks)
+	m.warmCache(services)
+	metrics.Synced()
+
+	m.Lock.Lock()
+	m.lastSync = time.Now()
+	m.Lock.Unlock()
+}
+
+// warmCache()
+//   Load the cache from the registry, and adopt the registrations of
+//   the leader that match the services this instance would register,
+//   so that it takes over without registering them again. Must be
+//   called with syncLock held.
+//
+func (m *Mesos) warmCache(services []*registry.Service) {
+	if !m.Registry.CacheCreate() {
+		m.clearCache()
+	}
+
+	m.warm = false
+	if err := m.LoadCache(); err != nil {
+		log.Warn("Unable to load the cache: ", err)
+		return
+	}
+	m.warm = true
+
+	if a, ok := m.Registry.(registry.Adopter); ok {
+		for _, s := range services {
+			a.CacheAdopt(s)
+		}
+	}
+}
+
+// elected()
+//   Ask the sync loop for a sync at once
+//
+func (m *Mesos) elected() {
+	select {
+	case m.electedChan <- struct{}{}:
+	default:
+	}
+}
+
+// Elected()
+//   Return a channel signaled when this instance is elected, or nil
+//   without --ha
+//
+func (m *Mesos) Elected() <-chan struct{} {
+	return m.electedChan
+}
+
+// reconcile()
+//   Periodically compare the registry with what the Consul agents
+//   actually hold, and repair agents that lost their services
+//
+func (m *Mesos) reconcile() {
+	r, ok := m.Registry.(registry.Reconciler)
+	if !ok || m.ReconcileInterval <= 0 {
+		return
+	}
+
+	if time.Since(m.lastReconcile) < m.ReconcileInterval {
+		return
+	}
+	m.lastReconcile = time.Now()
+
+	log.Info("Reconciling registry with agents")
+
+	r.Reconcile(m.agentIPs(), m.ServiceIdPrefix)
+}
+
+// agentIPs()
+//   Return the addresses of the Mesos agents and masters
+//
+func (m *Mesos) agentIPs() []string {
+	agents := make([]string, 0, len(m.Agents))
+	for _, a := range m.Agents {
+		agents = append(agents, a)
+	}
+	for _, ma := range m.getMasters() {
+		agents = append(agents, ma.Ip)
+	}
+
+	return agents
+}
+
 func (m *Mesos) loadState() (state.State, error) {
-	var err error
 	var sj state.State
 
 	log.Debug("loadState() called")
 
-	defer func() {
-		if rec := recover(); rec != nil {
-			err = errors.New("can't connect to Mesos")
+	if m.stateFiles != nil {
+		return m.loadStateFile()
+	}
+
+	if m.agentAddr != "" {
+		return m.loadFromAgent()
+	}
+
+	if m.masterURLs != nil {
+		if err := m.detectLeader(); err != nil {
+			return sj, err
 		}
-	}()
+	}
 
 	mh := m.getLeader()
 	if mh.Ip == "" {
@@ -152,63 +611,119 @@ func (m *Mesos) loadState() (state.State, error) {
 		return sj, errors.New("No master in zookeeper")
 	}
 
-	log.Infof("Zookeeper leader: %s:%s", mh.Ip, mh.PortString)
+	log.Infof("Leading master: %s:%s", mh.Ip, mh.PortString)
 
 	log.Info("reloading from master ", mh.Ip)
-	sj, err = m.loadFromMaster(mh.Ip, mh.PortString)
-
-	if rip := leaderIP(sj.Leader); rip != mh.Ip {
-		log.Warn("master changed to ", rip)
-		sj, err = m.loadFromMaster(rip, mh.PortString)
-	}
 
-	return sj, err
+	return m.loadFromMasters(mh)
 }
 
-func (m *Mesos) loadFromMaster(ip string, port string) (sj state.State, err error) {
-	url := "http://" + ip + ":" + port + "/master/state.json"
-
-	req, err := http.NewRequest("GET", url, nil)
-	req.Header.Set("Content-Type", "application/json")
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
+// clearCache()
+//   Drop every cached service, before loading the cache again
+//
+func (m *Mesos) clearCache() {
+	d, ok := m.Registry.(registry.CacheDumper)
+	if !ok {
 		return
 	}
 
-	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return
+	for _, e := range d.CacheDump() {
+		m.Registry.CacheDelete(e.Service.ID)
 	}
+}
 
-	err = json.Unmarshal(body, &sj)
-	if err != nil {
+// keepCache()
+//   Mark every cached service, so that the next Deregister keeps them
+//
+func (m *Mesos) keepCache() {
+	d, ok := m.Registry.(registry.CacheDumper)
+	if !ok {
 		return
 	}
 
-	return sj, nil
+	for _, e := range d.CacheDump() {
+		m.Registry.CacheMark(e.Service.ID)
+	}
 }
 
 func (m *Mesos) parseState(sj state.State) {
 	log.Info("Running parseState")
 
+	tasks := m.registerState(sj)
+
+	if gs, ok := m.Registry.(registry.GraceSetter); ok {
+		gs.SetDefaultGrace(m.grace)
+	}
+
+	if reason := m.zkPaused(); reason != "" {
+		// Keep every service, but still end the cycle of the registries
+		log.Warn("Not deregistering while Zookeeper is degraded: ", reason)
+		m.keepCache()
+	}
+	m.Registry.Deregister()
+
+	setTaskMetrics(tasks)
+}
+
+// registerState()
+//   Register the masters, the agents and the running tasks allowed
+//   by the filters. Returns the number of tasks registered, by
+//   framework.
+//
+func (m *Mesos) registerState(sj state.State) map[string]int {
+	m.services = nil
+	m.tasks = make(map[string]*TaskDebug)
+
 	m.RegisterHosts(sj)
 	log.Debug("Done running RegisterHosts")
 
+	tasks := make(map[string]int)
 	for _, fw := range sj.Frameworks {
-		if !m.FwPrivilege.Allowed(fw.Name) {
-			continue
-		}
+		fwAllowed, fwReason := m.FwPrivilege.Decision(fw.Name)
+
 		for _, task := range fw.Tasks {
+			d := &TaskDebug{
+				ID:              task.ID,
+				Name:            task.Name,
+				Framework:       fw.Name,
+				State:           task.State,
+				FrameworkFilter: fwReason,
+			}
+			m.tasks[task.ID] = d
+
+			if !fwAllowed {
+				d.Reason = "framework not allowed"
+				continue
+			}
+
 			agent, ok := m.Agents[task.SlaveID]
-			if ok && task.State == "TASK_RUNNING" {
-				task.SlaveIP = agent
-				m.registerTask(&task, agent)
+			if !ok {
+				d.Reason = "unknown agent " + task.SlaveID
+				continue
+			}
+			d.Agent = agent
+
+			if task.State != "TASK_RUNNING" {
+				d.Reason = "task not running"
+				continue
 			}
+
+			task.SlaveIP = agent
+			task.FrameworkName = fw.Name
+			m.registerTask(&task, agent, d)
+			tasks[fw.Name]++
 		}
 	}
 
-	m.Registry.Deregister()
+	return tasks
+}
+
+// setTaskMetrics()
+//   Publish the number of tasks registered, by framework
+//
+func setTaskMetrics(tasks map[string]int) {
+	metrics.Tasks.Reset()
+	for fw, n := range tasks {
+		metrics.Tasks.WithLabelValues(fw).Set(float64(n))
+	}
 }
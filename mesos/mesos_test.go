@@ -1,6 +1,16 @@
 package mesos
 
-import "testing"
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mantl/mesos-consul/config"
+	"github.com/mantl/mesos-consul/registry"
+	"github.com/mantl/mesos-consul/state"
+)
 
 func TestBuildTaskTag(t *testing.T) {
 	for _, tt := range []struct {
@@ -100,3 +110,314 @@ func taskMapEq(a, b map[string][]string) bool {
 
 	return true
 }
+
+func TestReload(t *testing.T) {
+	c := config.DefaultConfig()
+	c.TaskWhiteList = []string{"^web"}
+	c.TaskTag = []string{"web:public"}
+	c.ServiceTags = "dc1"
+	c.Separator = "."
+
+	m := &Mesos{}
+	if err := m.Reload(c); err != nil {
+		t.Fatal(err)
+	}
+
+	if !m.TaskPrivilege.Allowed("web") || m.TaskPrivilege.Allowed("db") {
+		t.Error("whitelist not applied")
+	}
+	if !taskMapEq(m.taskTag, map[string][]string{"web": []string{"public"}}) {
+		t.Errorf("task tags => %v", m.taskTag)
+	}
+	if !sliceEq(m.ServiceTags, []string{"dc1"}) || m.Separator != "." {
+		t.Errorf("service tags => %v, separator => %q", m.ServiceTags, m.Separator)
+	}
+
+	// An invalid configuration keeps the current rules
+	c = config.DefaultConfig()
+	c.TaskTag = []string{"invalid"}
+	if err := m.Reload(c); err == nil {
+		t.Error("Reload() accepted an invalid task-tag")
+	}
+	if m.TaskPrivilege.Allowed("db") {
+		t.Error("failed Reload() changed the rules")
+	}
+}
+
+func TestTaskMeta(t *testing.T) {
+	m := &Mesos{
+		ServiceMetaPrefix: "consul_meta_",
+		instance:          "mc1",
+		agentHostnames:    map[string]string{"S1": "agent1"},
+	}
+
+	task := &state.Task{
+		ID:            "web.1",
+		FrameworkID:   "F1",
+		FrameworkName: "marathon",
+		SlaveID:       "S1",
+		Labels: []state.Label{
+			{Key: "consul_meta_version", Value: "1.4.2"},
+			{Key: "consul_meta_mesos_task_id", Value: "override"},
+			{Key: "consul_meta_bad key", Value: "x"},
+			{Key: "consul_meta_consul-owned", Value: "x"},
+			{Key: "consul_meta_" + strings.Repeat("k", 128), Value: "long key"},
+			{Key: "consul_meta_" + strings.Repeat("k", 129), Value: "too long key"},
+			{Key: "consul_meta_notes", Value: strings.Repeat("v", 513)},
+			{Key: "tags", Value: "a,b"},
+		},
+	}
+
+	want := map[string]string{
+		"mesos_task_id":         "web.1",
+		"mesos_framework_id":    "F1",
+		"mesos_framework_name":  "marathon",
+		"mesos_agent_id":        "S1",
+		"mesos_agent_hostname":  "agent1",
+		"mesos_consul_instance": "mc1",
+		"version":               "1.4.2",
+	}
+	want[strings.Repeat("k", 128)] = "long key"
+	if meta := m.taskMeta(task); !reflect.DeepEqual(meta, want) {
+		t.Errorf("taskMeta => %v, want %v", meta, want)
+	}
+
+	m.ServiceMetaPrefix = ""
+	if meta := m.taskMeta(task); meta["version"] != "" {
+		t.Errorf("labels used without a prefix: %v", meta)
+	}
+}
+
+func TestGetChecks(t *testing.T) {
+	task := &state.Task{
+		ID: "web.1",
+		Labels: []state.Label{
+			{Key: "check_http", Value: "http://{host}:{port}/legacy"},
+			{Key: "check_1_http", Value: "http://{host}:{port}/ready"},
+			{Key: "check_1_interval", Value: "5s"},
+			{Key: "check_1_timeout", Value: "2s"},
+			{Key: "check_1_name", Value: "readiness"},
+			{Key: "check_0_tcp", Value: "{host}:{port}"},
+			{Key: "check_0_notes", Value: "liveness"},
+			{Key: "CHECK_10_TTL", Value: "30s"},
+			{Key: "check_2_interval", Value: "5s"},
+		},
+	}
+	cv := &CheckVar{Host: "10.0.0.1", Port: "31000"}
+
+	if c := GetCheck(task, cv, nil); c.HTTP != "http://10.0.0.1:31000/legacy" || c.TCP != "" || c.Interval != "" {
+		t.Errorf("GetCheck => %+v", c)
+	}
+
+	checks := GetChecks(task, cv, nil)
+	if len(checks) != 3 {
+		t.Fatalf("got %d checks, want 3: %+v", len(checks), checks)
+	}
+	if c := checks[0]; c.TCP != "10.0.0.1:31000" || c.Notes != "liveness" {
+		t.Errorf("check 0 => %+v", c)
+	}
+	if c := checks[1]; c.HTTP != "http://10.0.0.1:31000/ready" || c.Interval != "5s" || c.Timeout != "2s" || c.Name != "readiness" {
+		t.Errorf("check 1 => %+v", c)
+	}
+	if c := checks[2]; c.TTL != "30s" {
+		t.Errorf("check 10 => %+v", c)
+	}
+}
+
+func TestGetCheckOptions(t *testing.T) {
+	c := config.DefaultConfig()
+	c.CheckTimeout = "3s"
+	c.CheckHTTPMethod = "head"
+	c.CheckHTTPHeader = []string{"X-Auth: secret", "x-auth: other"}
+	c.CheckTLSSkipVerify = true
+	c.CheckDeregisterAfter = "10m"
+
+	defaults, err := buildCheckDefaults(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	task := &state.Task{
+		ID: "web.1",
+		Labels: []state.Label{
+			{Key: "check_http", Value: "https://{host}:{port}/health"},
+			{Key: "check_http_method", Value: "post"},
+			{Key: "check_http_header_Content-Type", Value: "application/json"},
+			{Key: "check_tls_skip_verify", Value: "false"},
+			{Key: "check_0_grpc", Value: "{host}:{port}/health"},
+			{Key: "check_0_grpc_use_tls", Value: "yes"},
+			{Key: "check_0_deregister_critical_service_after", Value: "1m"},
+			{Key: "check_1_script", Value: "/bin/check"},
+			{Key: "check_1_docker_container", Value: "abc123"},
+		},
+	}
+	cv := &CheckVar{Host: "10.0.0.1", Port: "31000"}
+
+	want := &registry.Check{
+		HTTP:    "https://10.0.0.1:31000/health",
+		Timeout: "3s",
+		Method:  "POST",
+		Header: map[string][]string{
+			"X-Auth":       {"secret", "other"},
+			"Content-Type": {"application/json"},
+		},
+		DeregisterCriticalServiceAfter: "10m",
+	}
+	if check := GetCheck(task, cv, defaults); !reflect.DeepEqual(check, want) {
+		t.Errorf("GetCheck => %+v, want %+v", check, want)
+	}
+	if len(defaults.Header["Content-Type"]) != 0 {
+		t.Error("labels changed the defaults")
+	}
+
+	checks := GetChecks(task, cv, defaults)
+	if len(checks) != 2 {
+		t.Fatalf("got %d checks, want 2", len(checks))
+	}
+	if ch := checks[0]; ch.GRPC != "10.0.0.1:31000/health" || ch.GRPCUseTLS || !ch.TLSSkipVerify || ch.DeregisterCriticalServiceAfter != "1m" {
+		t.Errorf("check 0 => %+v", ch)
+	}
+	if ch := checks[1]; ch.Script != "/bin/check" || ch.DockerContainerID != "abc123" || ch.Timeout != "3s" {
+		t.Errorf("check 1 => %+v", ch)
+	}
+
+	// The defaults alone don't make a check
+	if check := GetCheck(&state.Task{}, cv, defaults); !reflect.DeepEqual(check, registry.DefaultCheck()) {
+		t.Errorf("GetCheck without labels => %+v", check)
+	}
+}
+
+func TestWithHealth(t *testing.T) {
+	healthy := false
+	task := &state.Task{
+		Statuses: []state.Status{
+			{State: "TASK_RUNNING", Timestamp: 1, Healthy: &healthy, Message: "HTTP 500"},
+		},
+	}
+
+	m := &Mesos{}
+	if s := m.withHealth(task, &registry.Service{ID: "web"}); len(s.Checks) != 0 {
+		t.Errorf("check added while disabled: %+v", s.Checks)
+	}
+
+	m.HealthTTL = 3 * time.Minute
+	m.CheckDefaults = &registry.Check{DeregisterCriticalServiceAfter: "10m"}
+	s := m.withHealth(task, &registry.Service{ID: "web"})
+	if len(s.Checks) != 1 {
+		t.Fatalf("got %d checks, want 1", len(s.Checks))
+	}
+	if c := s.Checks[0]; c.ID != "mesos-health:web" || c.TTL != "3m0s" || c.Status != "critical" || c.Output != "Mesos reports the task unhealthy: HTTP 500" || c.DeregisterCriticalServiceAfter != "10m" {
+		t.Errorf("unexpected check: %+v", c)
+	}
+}
+
+func TestTaskGrace(t *testing.T) {
+	m := &Mesos{grace: registry.Grace{Cycles: 2, Duration: time.Minute}}
+
+	label := func(kv ...string) *state.Task {
+		task := &state.Task{ID: "web.1"}
+		for i := 0; i < len(kv); i += 2 {
+			task.Labels = append(task.Labels, state.Label{Key: kv[i], Value: kv[i+1]})
+		}
+		return task
+	}
+
+	if g := m.taskGrace(label()); g == nil || *g != m.grace {
+		t.Errorf("grace without labels => %+v", g)
+	}
+
+	for _, tt := range []struct {
+		task *state.Task
+		want registry.Grace
+	}{
+		{label("deregister_grace", "90s"), registry.Grace{Cycles: 2, Duration: 90 * time.Second}},
+		{label("heartbeats_before_remove", "0"), registry.Grace{Cycles: 0, Duration: time.Minute}},
+		{label("heartbeats_before_remove", "x", "deregister_grace", "-1s"), registry.Grace{Cycles: 2, Duration: time.Minute}},
+	} {
+		if g := m.taskGrace(tt.task); g == nil || *g != tt.want {
+			t.Errorf("taskGrace(%v) => %+v, want %+v", tt.task.Labels, g, tt.want)
+		}
+	}
+}
+
+// testElection is an election decided by the test
+type testElection struct {
+	leader bool
+}
+
+func (e *testElection) IsLeader() bool { return e.leader }
+func (e *testElection) Role() string   { return "" }
+
+// catalogRegistry loads its cache from a catalog, adopts the services
+// and records what it registers
+type catalogRegistry struct {
+	registry.Cache
+	catalog    []*registry.Service
+	loads      int
+	registered []string
+}
+
+func (r *catalogRegistry) CacheLoad(host, prefix string) error {
+	r.loads++
+	for _, s := range r.catalog {
+		r.CacheAdd(s, "")
+	}
+	return nil
+}
+
+func (r *catalogRegistry) CacheAdopt(s *registry.Service) {
+	if e := r.Cached(s.ID); e != nil && e.Fingerprint == "" {
+		e.Fingerprint = s.Fingerprint()
+	}
+}
+
+func (r *catalogRegistry) Register(s *registry.Service) {
+	if e := r.Cached(s.ID); e != nil && e.Fingerprint == s.Fingerprint() {
+		r.CacheMark(s.ID)
+		return
+	}
+	r.registered = append(r.registered, s.ID)
+	r.CacheAdd(s, s.Fingerprint())
+}
+
+func (r *catalogRegistry) Deregister() {
+	r.CacheSweep(func(*registry.CachedService) bool { return true })
+}
+
+func TestStandbyWarmsCache(t *testing.T) {
+	var sj state.State
+	if err := json.Unmarshal([]byte(masterStateJSON), &sj); err != nil {
+		t.Fatal(err)
+	}
+
+	// What the leader registered
+	leader := newTestMesos(t, &registry.Recorder{})
+	desired, _ := leader.desiredServices(sj)
+	if len(desired) == 0 {
+		t.Fatal("nothing to register")
+	}
+
+	r := &catalogRegistry{Cache: registry.NewCache("test"), catalog: desired}
+	m := newTestMesos(t, r)
+	e := &testElection{}
+	m.election = e
+
+	m.syncState(sj)
+	m.syncState(sj)
+	if len(r.registered) != 0 {
+		t.Errorf("the standby registered %v", r.registered)
+	}
+	if r.loads != 2 {
+		t.Errorf("the standby loaded the cache %d times, want every sync", r.loads)
+	}
+
+	// Elected: the cache is warm, nothing to register again
+	e.leader = true
+	m.syncState(sj)
+	if len(r.registered) != 0 {
+		t.Errorf("registered %v again once elected", r.registered)
+	}
+	if r.loads != 2 {
+		t.Errorf("loaded the cache again once elected")
+	}
+}
@@ -0,0 +1,73 @@
+package mesos
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/mantl/mesos-consul/registry"
+	"github.com/mantl/mesos-consul/state"
+)
+
+// Plan()
+//   Compute the changes a sync would make, without making them. The
+//   desired services come from the state of the leading master, the
+//   actual ones from the agents of the registry.
+//
+func (m *Mesos) Plan() ([]registry.ServiceDiff, error) {
+	lister, ok := m.Registry.(registry.Lister)
+	if !ok {
+		return nil, errors.New("the registry can't list its services")
+	}
+
+	sj, err := m.loadState()
+	if err != nil {
+		return nil, fmt.Errorf("loadState failed: %s", err)
+	}
+	// Agent mode reads the state of an agent, which has no leader
+	if sj.Leader == "" && m.agentAddr == "" {
+		return nil, errors.New("Empty master")
+	}
+
+	m.syncLock.Lock()
+	desired, agents := m.planServices(sj)
+	m.syncLock.Unlock()
+
+	actual, err := lister.List(agents, m.ServiceIdPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	return registry.Diff(desired, actual), nil
+}
+
+// desiredServices()
+//   Run the registration logic against a recorder instead of the
+//   registry. Returns the services and the number of tasks by
+//   framework. Must be called with syncLock held.
+//
+func (m *Mesos) desiredServices(sj state.State) ([]*registry.Service, map[string]int) {
+	r := m.Registry
+	recorder := &registry.Recorder{}
+	m.Registry = recorder
+	defer func() { m.Registry = r }()
+
+	tasks := m.registerState(sj)
+
+	return recorder.Services, tasks
+}
+
+// planServices()
+//   The desired services and the agents to compare them with,
+//   leaving the agents and the debug information of the last sync
+//   as they were. Must be called with syncLock held.
+//
+func (m *Mesos) planServices(sj state.State) ([]*registry.Service, []string) {
+	agents, hostnames, services, tasks := m.Agents, m.agentHostnames, m.services, m.tasks
+	defer func() {
+		m.Agents, m.agentHostnames, m.services, m.tasks = agents, hostnames, services, tasks
+	}()
+
+	desired, _ := m.desiredServices(sj)
+
+	return desired, m.agentIPs()
+}
@@ -0,0 +1,58 @@
+package mesos
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mantl/mesos-consul/registry"
+)
+
+// listRecorder lists no service, and records the agents it was asked
+type listRecorder struct {
+	registry.Recorder
+	agents []string
+}
+
+func (l *listRecorder) List(agents []string, serviceIdPrefix string) ([]*registry.Service, error) {
+	l.agents = agents
+	return nil, nil
+}
+
+func TestPlanAgentMode(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, agentStateJSON)
+	}))
+	defer ts.Close()
+
+	l := &listRecorder{}
+	m := newTestMesos(t, l)
+	m.agentAddr = strings.TrimPrefix(ts.URL, "http://")
+
+	// What the last sync left
+	agents := map[string]string{"S0": "10.0.0.9"}
+	services := []*registry.Service{{ID: "mesos-consul:10.0.0.9:old:80"}}
+	m.Agents = agents
+	m.services = services
+
+	diffs, err := m.Plan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diffs) != 1 || diffs[0].Action != registry.DiffAdd {
+		t.Errorf("Plan() => %+v, want the task added", diffs)
+	}
+	if !reflect.DeepEqual(l.agents, []string{"10.0.0.1"}) {
+		t.Errorf("listed agents %v, want the local agent", l.agents)
+	}
+	if len(l.Services) != 0 {
+		t.Errorf("Plan() registered %v", l.Services)
+	}
+
+	if !reflect.DeepEqual(m.Agents, agents) || !reflect.DeepEqual(m.services, services) {
+		t.Errorf("Plan() changed the state of the last sync: %v %v", m.Agents, m.services)
+	}
+}
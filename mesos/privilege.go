@@ -1,6 +1,8 @@
 package mesos
 
 import (
+	"fmt"
+
 	log "github.com/sirupsen/logrus"
 )
 
@@ -29,3 +31,27 @@ func (p *Privilege) Allowed(name string) bool {
 
 	return true
 }
+
+// Decision returns whether name is allowed, and why
+func (p *Privilege) Decision(name string) (bool, string) {
+	allowed := p.Allowed(name)
+
+	if p.WhiteList.Regex != nil {
+		pattern, ok := p.WhiteList.Match(name)
+		if !ok {
+			return allowed, "no whitelist pattern matches"
+		}
+		if !allowed {
+			if bp, ok := p.BlackList.Match(name); ok {
+				return allowed, fmt.Sprintf("whitelist pattern '%s' matches, but blacklist pattern '%s' matches too", pattern, bp)
+			}
+		}
+		return allowed, fmt.Sprintf("whitelist pattern '%s' matches", pattern)
+	}
+
+	if bp, ok := p.BlackList.Match(name); ok {
+		return allowed, fmt.Sprintf("blacklist pattern '%s' matches", bp)
+	}
+
+	return allowed, "no whitelist or blacklist pattern matches"
+}
@@ -0,0 +1,56 @@
+package mesos
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// maxRecordSize bounds the size of a single RecordIO record so that a
+// corrupt length prefix can't make us allocate unbounded memory.
+const maxRecordSize = 256 * 1024 * 1024
+
+// recordReader reads RecordIO framed records as sent by the Mesos
+// v1 HTTP APIs: "<length>\n<record>".
+type recordReader struct {
+	r *bufio.Reader
+}
+
+func newRecordReader(r io.Reader) *recordReader {
+	return &recordReader{
+		r: bufio.NewReader(r),
+	}
+}
+
+// ReadRecord()
+//   Return the next record from the stream
+//
+func (rr *recordReader) ReadRecord() ([]byte, error) {
+	header, err := rr.r.ReadString('\n')
+	if err != nil {
+		if err == io.EOF && header != "" {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	size, err := strconv.ParseUint(strings.TrimSpace(header), 10, 64)
+	if err != nil {
+		return nil, errors.New("invalid RecordIO length: " + strings.TrimSpace(header))
+	}
+	if size > maxRecordSize {
+		return nil, errors.New("RecordIO record too large: " + strings.TrimSpace(header))
+	}
+
+	record := make([]byte, size)
+	if _, err := io.ReadFull(rr.r, record); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	return record, nil
+}
@@ -0,0 +1,46 @@
+package mesos
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestRecordReader(t *testing.T) {
+	for _, tt := range []struct {
+		in      string
+		records []string
+		err     error
+	}{
+		{"", nil, io.EOF},
+		{"5\nhello", []string{"hello"}, io.EOF},
+		{"5\nhello3\nfoo", []string{"hello", "foo"}, io.EOF},
+		{"0\n2\n{}", []string{"", "{}"}, io.EOF},
+		{"5\nhel", nil, io.ErrUnexpectedEOF},
+		{"5", nil, io.ErrUnexpectedEOF},
+	} {
+		rr := newRecordReader(strings.NewReader(tt.in))
+
+		var records []string
+		var err error
+		for {
+			var r []byte
+			r, err = rr.ReadRecord()
+			if err != nil {
+				break
+			}
+			records = append(records, string(r))
+		}
+
+		if err != tt.err {
+			t.Errorf("ReadRecord(%q) error => %v, want %v", tt.in, err, tt.err)
+		}
+		if !sliceEq(records, tt.records) {
+			t.Errorf("ReadRecord(%q) => %q, want %q", tt.in, records, tt.records)
+		}
+	}
+
+	if _, err := newRecordReader(strings.NewReader("x\n")).ReadRecord(); err == nil {
+		t.Error("ReadRecord() with an invalid length should fail")
+	}
+}
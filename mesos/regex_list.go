@@ -8,8 +8,11 @@ import (
 )
 
 type RegexList struct {
-	List []string
+	List  []string
 	Regex *regexp.Regexp
+
+	// Each pattern on its own, to report which one matched
+	patterns []*regexp.Regexp
 }
 
 func NewRegexList(l []string) *RegexList {
@@ -34,6 +37,13 @@ func (rl *RegexList) Compile(l []string) {
 		}
 
 		rl.Regex = re
+
+		rl.patterns = make([]*regexp.Regexp, 0, len(l))
+		for _, p := range l {
+			if pre, err := regexp.Compile(p); err == nil {
+				rl.patterns = append(rl.patterns, pre)
+			}
+		}
 	}
 }
 
@@ -45,3 +55,14 @@ func (rl *RegexList) MatchString(s string, def bool) bool {
 	// Return default value if no regex
 	return def
 }
+
+// Match returns the first pattern of the list that matches s
+func (rl *RegexList) Match(s string) (string, bool) {
+	for _, re := range rl.patterns {
+		if re.MatchString(s) {
+			return re.String(), true
+		}
+	}
+
+	return "", false
+}
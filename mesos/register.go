@@ -2,8 +2,10 @@ package mesos
 
 import (
 	"fmt"
+	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/mantl/mesos-consul/registry"
 	"github.com/mantl/mesos-consul/state"
@@ -29,6 +31,7 @@ func (m *Mesos) RegisterHosts(s state.State) {
 	log.Debug("Running RegisterHosts")
 
 	m.Agents = make(map[string]string)
+	m.agentHostnames = make(map[string]string)
 
 	// Register slaves
 	for _, f := range s.Slaves {
@@ -36,6 +39,12 @@ func (m *Mesos) RegisterHosts(s state.State) {
 		port := toPort(f.PID.Port)
 
 		m.Agents[f.ID] = agent
+		m.agentHostnames[f.ID] = f.Hostname
+
+		if m.agentAddr != "" {
+			// Agent mode only registers tasks
+			continue
+		}
 
 		m.registerHost(&registry.Service{
 			ID:      fmt.Sprintf("%s:%s:%s:%s", m.ServiceIdPrefix, m.ServiceName, f.ID, f.Hostname),
@@ -45,12 +54,16 @@ func (m *Mesos) RegisterHosts(s state.State) {
 			Agent:   agent,
 			Tags:    m.agentTags("agent", "follower"),
 			Check: &registry.Check{
-				HTTP:     fmt.Sprintf("http://%s:%d/slave(1)/health", agent, port),
+				HTTP:     m.mesosAPI().url(fmt.Sprintf("%s:%d", agent, port), "/slave(1)/health"),
 				Interval: "10s",
 			},
 		})
 	}
 
+	if m.agentAddr != "" {
+		return
+	}
+
 	// Register masters
 	mas := m.getMasters()
 	for _, ma := range mas {
@@ -69,7 +82,7 @@ func (m *Mesos) RegisterHosts(s state.State) {
 			Agent:   ma.Ip,
 			Tags:    tags,
 			Check: &registry.Check{
-				HTTP:     fmt.Sprintf("http://%s:%d/master/health", ma.Ip, ma.Port),
+				HTTP:     m.mesosAPI().url(fmt.Sprintf("%s:%d", ma.Ip, ma.Port), "/master/health"),
 				Interval: "10s",
 			},
 		}
@@ -79,27 +92,27 @@ func (m *Mesos) RegisterHosts(s state.State) {
 }
 
 func (m *Mesos) registerHost(s *registry.Service) {
-	h := m.Registry.CacheLookup(s.ID)
-	if h != nil {
-		log.Infof("Host found. Comparing tags: (%v, %v)", h.Tags, s.Tags)
-
-		if sliceEq(s.Tags, h.Tags) {
-			m.Registry.CacheMark(s.ID)
-
-			// Tags are the same. Return
-			return
-		}
-
-		log.Info("Tags changed. Re-registering")
+	m.register(s, nil)
+}
 
-		// Delete cache entry. It will be re-created below
-		m.Registry.CacheDelete(s.ID)
+// register()
+//   Hand a service to the registry and record it for the debug API.
+//   d is the task the service was generated from, nil for hosts.
+//
+func (m *Mesos) register(s *registry.Service, d *TaskDebug) {
+	m.services = append(m.services, s)
+	if d != nil {
+		d.ServiceIDs = append(d.ServiceIDs, s.ID)
+		d.Registered = true
+		d.Reason = "registered"
 	}
 
+	// The registry compares every field with what it registered
+	// before, and only registers again when something changed.
 	m.Registry.Register(s)
 }
 
-func (m *Mesos) registerTask(t *state.Task, agent string) {
+func (m *Mesos) registerTask(t *state.Task, agent string, d *TaskDebug) {
 	var tags []string
 
 	registered := false
@@ -110,8 +123,13 @@ func (m *Mesos) registerTask(t *state.Task, agent string) {
 		tname = cleanName(t.Label("overrideTaskName"), m.Separator)
 		log.Debugf("overrideTaskName to : (%v)", tname)
 	}
-	if !m.TaskPrivilege.Allowed(tname) {
+	d.ServiceName = tname
+
+	allowed, reason := m.TaskPrivilege.Decision(tname)
+	d.TaskFilter = reason
+	if !allowed {
 		// Task not allowed to be registered
+		d.Reason = "task not allowed"
 		return
 	}
 
@@ -145,6 +163,9 @@ func (m *Mesos) registerTask(t *state.Task, agent string) {
 
 	tags = buildRegisterTaskTags(tname, tags, m.taskTag)
 
+	meta := m.taskMeta(t)
+	grace := m.taskGrace(t)
+
 	for key := range t.DiscoveryInfo.Ports.DiscoveryPorts {
 		// We append -portN to ports after the first.
 		// This is done to preserve compatibility with
@@ -169,18 +190,24 @@ func (m *Mesos) registerTask(t *state.Task, agent string) {
 			porttags = []string{}
 		}
 		if discoveryPort.Name != "" {
-			m.Registry.Register(&registry.Service{
-				ID:      fmt.Sprintf("%s:%s:%s:%s:%d", m.ServiceIdPrefix, agent, svcName, address, discoveryPort.Number),
-				Name:    svcName,
-				Port:    toPort(servicePort),
-				Address: address,
-				Tags:    append(append(tags, serviceName), porttags...),
-				Check: GetCheck(t, &CheckVar{
-					Host: toIP(address),
-					Port: servicePort,
-				}),
-				Agent: toIP(agent),
-			})
+			cv := &CheckVar{
+				Host: toIP(address),
+				Port: servicePort,
+			}
+			m.register(m.withHealth(t, &registry.Service{
+				ID:        fmt.Sprintf("%s:%s:%s:%s:%d", m.ServiceIdPrefix, agent, svcName, address, discoveryPort.Number),
+				Name:      svcName,
+				Port:      toPort(servicePort),
+				Address:   address,
+				Tags:      append(append(tags, serviceName), porttags...),
+				Check:     GetCheck(t, cv, m.CheckDefaults),
+				Checks:    GetChecks(t, cv, m.CheckDefaults),
+				Agent:     toIP(agent),
+				Meta:      meta,
+				Grace:     grace,
+				TaskID:    t.ID,
+				Framework: t.FrameworkName,
+			}), d)
 			registered = true
 		}
 	}
@@ -200,34 +227,173 @@ func (m *Mesos) registerTask(t *state.Task, agent string) {
 			if key > 0 {
 				svcName = fmt.Sprintf("%s-port%d", svcName, key+1)
 			}
-			m.Registry.Register(&registry.Service{
-				ID:      fmt.Sprintf("%s:%s:%s:%s:%s", m.ServiceIdPrefix, agent, svcName, address, port),
-				Name:    svcName,
-				Port:    toPort(port),
-				Address: address,
-				Tags:    tags,
-				Check: GetCheck(t, &CheckVar{
-					Host: toIP(address),
-					Port: port,
-				}),
-				Agent: toIP(agent),
-			})
+			cv := &CheckVar{
+				Host: toIP(address),
+				Port: port,
+			}
+			m.register(m.withHealth(t, &registry.Service{
+				ID:        fmt.Sprintf("%s:%s:%s:%s:%s", m.ServiceIdPrefix, agent, svcName, address, port),
+				Name:      svcName,
+				Port:      toPort(port),
+				Address:   address,
+				Tags:      tags,
+				Check:     GetCheck(t, cv, m.CheckDefaults),
+				Checks:    GetChecks(t, cv, m.CheckDefaults),
+				Agent:     toIP(agent),
+				Meta:      meta,
+				Grace:     grace,
+				TaskID:    t.ID,
+				Framework: t.FrameworkName,
+			}), d)
 			registered = true
 		}
 	}
 
 	if !registered {
-		m.Registry.Register(&registry.Service{
-			ID:      fmt.Sprintf("%s:%s-%s:%s", m.ServiceIdPrefix, agent, tname, address),
-			Name:    tname,
-			Address: address,
-			Tags:    tags,
-			Check: GetCheck(t, &CheckVar{
-				Host: toIP(address),
-			}),
-			Agent: toIP(agent),
-		})
+		cv := &CheckVar{
+			Host: toIP(address),
+		}
+		m.register(m.withHealth(t, &registry.Service{
+			ID:        fmt.Sprintf("%s:%s-%s:%s", m.ServiceIdPrefix, agent, tname, address),
+			Name:      tname,
+			Address:   address,
+			Tags:      tags,
+			Check:     GetCheck(t, cv, m.CheckDefaults),
+			Checks:    GetChecks(t, cv, m.CheckDefaults),
+			Agent:     toIP(agent),
+			Meta:      meta,
+			Grace:     grace,
+			TaskID:    t.ID,
+			Framework: t.FrameworkName,
+		}), d)
+	}
+}
+
+// withHealth()
+//   Add a TTL check following the Mesos health of the task to the
+//   service, when HealthTTL is set. The registry pushes its status on
+//   every sync. Like the other checks, it has Consul deregister the
+//   service once critical for check_deregister_critical_service_after,
+//   which covers mesos-consul going away.
+//
+func (m *Mesos) withHealth(t *state.Task, s *registry.Service) *registry.Service {
+	if m.HealthTTL <= 0 {
+		return s
 	}
+
+	c := &registry.Check{
+		ID:   "mesos-health:" + s.ID,
+		Name: "Mesos health",
+		TTL:  m.HealthTTL.String(),
+
+		DeregisterCriticalServiceAfter: t.Label("check_deregister_critical_service_after"),
+	}
+	if c.DeregisterCriticalServiceAfter == "" && m.CheckDefaults != nil {
+		c.DeregisterCriticalServiceAfter = m.CheckDefaults.DeregisterCriticalServiceAfter
+	}
+
+	healthy, message := t.Health()
+	switch {
+	case healthy == nil:
+		c.Status = "warning"
+		c.Output = "No Mesos health check result"
+	case *healthy:
+		c.Status = "passing"
+		c.Output = "Mesos reports the task healthy"
+	default:
+		c.Status = "critical"
+		c.Output = "Mesos reports the task unhealthy"
+	}
+	if message != "" {
+		c.Output += ": " + message
+	}
+
+	s.Checks = append(s.Checks, c)
+
+	return s
+}
+
+// taskGrace()
+//   Return the deregistration grace set by the heartbeats_before_remove
+//   and deregister_grace labels of the task, on top of the defaults
+//
+func (m *Mesos) taskGrace(t *state.Task) *registry.Grace {
+	cycles := t.Label("heartbeats_before_remove")
+	duration := t.Label("deregister_grace")
+
+	g := m.grace
+	if cycles != "" {
+		n, err := strconv.Atoi(cycles)
+		if err != nil || n < 0 {
+			log.Warnf("Task %s: ignoring heartbeats_before_remove label '%s'", t.ID, cycles)
+		} else {
+			g.Cycles = n
+		}
+	}
+	if duration != "" {
+		d, err := time.ParseDuration(duration)
+		if err != nil || d < 0 {
+			log.Warnf("Task %s: ignoring deregister_grace label '%s'", t.ID, duration)
+		} else {
+			g.Duration = d
+		}
+	}
+
+	return &g
+}
+
+// Consul only accepts these metadata keys, and values up to
+// metaValueMax characters
+var metaKeyRe = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,128}$`)
+
+const metaValueMax = 512
+
+// taskMeta()
+//   Build the metadata of the services of a task: the built-in keys,
+//   then the labels carrying ServiceMetaPrefix with the prefix removed.
+//   Labels can't override the built-in keys.
+//
+func (m *Mesos) taskMeta(t *state.Task) map[string]string {
+	meta := map[string]string{
+		"mesos_task_id":        t.ID,
+		"mesos_framework_id":   t.FrameworkID,
+		"mesos_framework_name": t.FrameworkName,
+		"mesos_agent_id":       t.SlaveID,
+		"mesos_agent_hostname": m.agentHostnames[t.SlaveID],
+		registry.InstanceMeta:  m.instance,
+	}
+
+	if m.ServiceMetaPrefix != "" {
+		for _, l := range t.Labels {
+			if !strings.HasPrefix(l.Key, m.ServiceMetaPrefix) {
+				continue
+			}
+
+			key := strings.TrimPrefix(l.Key, m.ServiceMetaPrefix)
+			if !metaKeyRe.MatchString(key) || strings.HasPrefix(key, "consul-") {
+				log.Warnf("Task %s: ignoring label %s, '%s' is not a valid metadata key", t.ID, l.Key, key)
+				continue
+			}
+			if _, ok := meta[key]; ok {
+				log.Warnf("Task %s: ignoring label %s, '%s' is a built-in metadata key", t.ID, l.Key, key)
+				continue
+			}
+
+			meta[key] = l.Value
+		}
+	}
+
+	for k, v := range meta {
+		if len(v) > metaValueMax {
+			// Consul would reject the whole registration
+			log.Warnf("Task %s: dropping metadata '%s', its value is longer than %d characters", t.ID, k, metaValueMax)
+			delete(meta, k)
+		} else if v == "" {
+			delete(meta, k)
+		}
+	}
+
+	return meta
 }
 
 // buildRegisterTaskTags takes a cleaned task name, a slice of starting tags, and the processed
@@ -0,0 +1,126 @@
+package mesos
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/mantl/mesos-consul/state"
+
+	proto "github.com/mesos/mesos-go/mesosproto"
+	"github.com/mesos/mesos-go/upid"
+	log "github.com/sirupsen/logrus"
+)
+
+// stateFiles()
+//   Return the state snapshots to replay: the file itself, or the
+//   .json files of a directory ordered by name
+//
+func stateFiles(path string) ([]string, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if !fi.IsDir() {
+		return []string{path}, nil
+	}
+
+	files, err := filepath.Glob(filepath.Join(path, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no .json files in %s", path)
+	}
+	sort.Strings(files)
+
+	return files, nil
+}
+
+// loadStateFile()
+//   Read the next snapshot instead of asking the leading master.
+//   The last snapshot is read again once all were read.
+//
+func (m *Mesos) loadStateFile() (state.State, error) {
+	var sj state.State
+
+	path := m.stateFiles[m.stateIndex]
+	if m.stateIndex < len(m.stateFiles)-1 {
+		m.stateIndex++
+	}
+
+	log.Info("reloading from state file ", path)
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return sj, err
+	}
+
+	if err := json.Unmarshal(b, &sj); err != nil {
+		return sj, fmt.Errorf("%s: %s", path, err)
+	}
+
+	if err := m.setLeader(sj.Leader); err != nil {
+		return sj, fmt.Errorf("%s: %s", path, err)
+	}
+
+	return sj, nil
+}
+
+// setLeader()
+//   Use the leader of a snapshot as the only master, in place of
+//   the information from Zookeeper
+//
+func (m *Mesos) setLeader(leader string) error {
+	if leader == "" {
+		return errors.New("no leader in state")
+	}
+
+	pid, err := upid.Parse(leader)
+	if err != nil {
+		return err
+	}
+
+	ip := toIP(pid.Host)
+	port, err := strconv.Atoi(pid.Port)
+	if err != nil {
+		return fmt.Errorf("invalid leader port '%s'", pid.Port)
+	}
+	p32 := int32(port)
+
+	mi := &proto.MasterInfo{
+		Id: &leader,
+		Address: &proto.Address{
+			Hostname: &ip,
+			Ip:       &ip,
+			Port:     &p32,
+		},
+	}
+
+	m.Lock.Lock()
+	defer m.Lock.Unlock()
+
+	m.Leader = mi
+	m.Masters = []*proto.MasterInfo{mi}
+
+	return nil
+}
+
+// Replay()
+//   Sync every snapshot once, in order
+//
+func (m *Mesos) Replay() error {
+	for _, path := range m.stateFiles {
+		if err := m.Refresh(); err != nil {
+			return fmt.Errorf("%s: %s", path, err)
+		}
+	}
+
+	return nil
+}
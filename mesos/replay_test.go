@@ -0,0 +1,103 @@
+package mesos
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mantl/mesos-consul/config"
+	"github.com/mantl/mesos-consul/registry"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+// newReplay returns a Mesos that reads the snapshots at path and
+// records the services
+func newReplay(t *testing.T, path string) *Mesos {
+	files, err := stateFiles(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := &Mesos{
+		Registry:        &registry.Recorder{},
+		IpOrder:         []string{"netinfo", "mesos", "host"},
+		ServiceIdPrefix: "mesos-consul",
+		stateFiles:      files,
+	}
+	if err := m.Reload(config.DefaultConfig()); err != nil {
+		t.Fatal(err)
+	}
+
+	return m
+}
+
+func TestReplayGolden(t *testing.T) {
+	states, err := filepath.Glob("testdata/replay/*.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range states {
+		m := newReplay(t, path)
+		if err := m.Replay(); err != nil {
+			t.Errorf("%s: %s", path, err)
+			continue
+		}
+
+		got, err := json.MarshalIndent(m.Services(), "", "  ")
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, '\n')
+
+		golden := strings.TrimSuffix(path, ".json") + ".golden"
+		if *update {
+			if err := ioutil.WriteFile(golden, got, 0644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+
+		want, err := ioutil.ReadFile(golden)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s: services differ from %s:\n%s", path, golden, got)
+		}
+	}
+}
+
+func TestReplaySnapshots(t *testing.T) {
+	m := newReplay(t, "testdata/snapshots")
+	if len(m.stateFiles) != 2 {
+		t.Fatalf("got %d snapshots, want 2", len(m.stateFiles))
+	}
+
+	if err := m.Replay(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The leader comes from the last snapshot
+	if mh := m.getLeader(); mh.Ip != "10.0.0.11" || mh.PortString != "5050" {
+		t.Errorf("leader => %s:%s, want 10.0.0.11:5050", mh.Ip, mh.PortString)
+	}
+
+	var ids []string
+	for _, s := range m.Services() {
+		ids = append(ids, s.ID)
+	}
+	want := []string{
+		"mesos-consul:10.0.0.1:web:10.0.0.1:31002",
+		"mesos-consul:mesos:10.0.0.11:5050",
+		"mesos-consul:mesos:S1:agent1",
+	}
+	if !sliceEq(ids, want) {
+		t.Errorf("services => %q, want %q", ids, want)
+	}
+}
@@ -1,11 +1,16 @@
 package mesos
 
 import (
+	"net/http"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/mantl/mesos-consul/registry"
 	"github.com/mantl/mesos-consul/state"
+
+	log "github.com/sirupsen/logrus"
 )
 
 type CheckVar struct {
@@ -18,31 +23,156 @@ var globalCV *CheckVar
 // Task Methods
 
 // GetCheck()
-//   Build a Check structure from the Task labels
+//   Build a Check structure from the check_* labels of the Task.
+//   Fields the labels don't set come from defaults, which may be nil.
 //
-func GetCheck(t *state.Task, cv *CheckVar) *registry.Check {
-	c := registry.DefaultCheck()
+func GetCheck(t *state.Task, cv *CheckVar, defaults *registry.Check) *registry.Check {
+	c := newCheck(defaults)
 
 	for _, l := range t.Labels {
 		k := strings.ToLower(l.Key)
 
-		switch k {
-		case "check_http":
-			c.HTTP = interpolate(cv, l.Value)
-		case "check_script":
-			c.Script = interpolate(cv, l.Value)
-		case "check_tcp":
-			c.TCP = interpolate(cv, l.Value)
-		case "check_ttl":
-			c.TTL = interpolate(cv, l.Value)
-		case "check_interval":
-			c.Interval = l.Value
+		if strings.HasPrefix(k, "check_") {
+			setCheckField(c, strings.TrimPrefix(k, "check_"), l.Value, cv)
 		}
 	}
 
+	if !c.HasType() {
+		// The defaults alone don't make a check
+		return registry.DefaultCheck()
+	}
+
 	return c
 }
 
+var checkIndexRe = regexp.MustCompile(`^check_([0-9]+)_(.+)$`)
+
+// GetChecks()
+//   Build the additional checks of the Task from its indexed
+//   check_<n>_* labels, ordered by index. Checks without a http,
+//   script, tcp, ttl or grpc label are left out.
+//
+func GetChecks(t *state.Task, cv *CheckVar, defaults *registry.Check) []*registry.Check {
+	checks := make(map[int]*registry.Check)
+
+	for _, l := range t.Labels {
+		match := checkIndexRe.FindStringSubmatch(strings.ToLower(l.Key))
+		if match == nil {
+			continue
+		}
+
+		i, err := strconv.Atoi(match[1])
+		if err != nil {
+			continue
+		}
+		if _, ok := checks[i]; !ok {
+			checks[i] = newCheck(defaults)
+		}
+
+		setCheckField(checks[i], match[2], l.Value, cv)
+	}
+
+	indexes := make([]int, 0, len(checks))
+	for i := range checks {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+
+	var rval []*registry.Check
+	for _, i := range indexes {
+		c := checks[i]
+		if !c.HasType() {
+			log.Warnf("Task %s: check %d has no http, script, tcp, ttl or grpc label", t.ID, i)
+			continue
+		}
+
+		rval = append(rval, c)
+	}
+
+	return rval
+}
+
+// setCheckField()
+//   Set the field of a check named by a label, without its
+//   check_ or check_<n>_ prefix
+//
+func setCheckField(c *registry.Check, field, value string, cv *CheckVar) {
+	switch field {
+	case "http":
+		c.HTTP = interpolate(cv, value)
+	case "script":
+		c.Script = interpolate(cv, value)
+	case "tcp":
+		c.TCP = interpolate(cv, value)
+	case "ttl":
+		c.TTL = interpolate(cv, value)
+	case "interval":
+		c.Interval = value
+	case "timeout":
+		c.Timeout = value
+	case "name":
+		c.Name = value
+	case "notes":
+		c.Notes = value
+	case "http_method":
+		c.Method = strings.ToUpper(value)
+	case "tls_skip_verify":
+		c.TLSSkipVerify = parseCheckBool(field, value, c.TLSSkipVerify)
+	case "grpc":
+		c.GRPC = interpolate(cv, value)
+	case "grpc_use_tls":
+		c.GRPCUseTLS = parseCheckBool(field, value, c.GRPCUseTLS)
+	case "docker_container":
+		c.DockerContainerID = value
+	case "deregister_critical_service_after":
+		c.DeregisterCriticalServiceAfter = value
+	default:
+		if strings.HasPrefix(field, "http_header_") {
+			// Label keys are lowercased, Content-Type is given as
+			// check_http_header_content-type
+			name := http.CanonicalHeaderKey(strings.TrimPrefix(field, "http_header_"))
+			if c.Header == nil {
+				c.Header = make(map[string][]string)
+			}
+			c.Header[name] = []string{value}
+		}
+	}
+}
+
+// parseCheckBool()
+//   Parse a boolean check label, keeping the current value when it
+//   isn't one
+//
+func parseCheckBool(field, value string, current bool) bool {
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Warnf("Ignoring check label %s: '%s' is not a boolean", field, value)
+		return current
+	}
+
+	return b
+}
+
+// newCheck()
+//   Return a copy of the check defaults, or an empty check without
+//   defaults
+//
+func newCheck(defaults *registry.Check) *registry.Check {
+	if defaults == nil {
+		return registry.DefaultCheck()
+	}
+
+	c := *defaults
+	if defaults.Header != nil {
+		c.Header = make(map[string][]string, len(defaults.Header))
+		for k, v := range defaults.Header {
+			c.Header[k] = append([]string(nil), v...)
+		}
+	}
+
+	return &c
+}
+
 // Replace {variables} with values
 //
 func interpolate(cv *CheckVar, s string) string {
@@ -0,0 +1,227 @@
+package mesos
+
+import (
+	"sort"
+
+	"github.com/mantl/mesos-consul/state"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// streamState holds the cluster state built from the SUBSCRIBED snapshot
+// and kept current by the events of the Operator API stream.
+type streamState struct {
+	leader     string
+	frameworks map[string]state.Framework
+	tasks      map[string]state.Task
+	agents     map[string]state.Slave
+}
+
+func newStreamState(leader string) *streamState {
+	return &streamState{
+		leader:     leader,
+		frameworks: make(map[string]state.Framework),
+		tasks:      make(map[string]state.Task),
+		agents:     make(map[string]state.Slave),
+	}
+}
+
+// isTerminal returns true if a task in the given state will never run again
+//
+func isTerminal(s string) bool {
+	switch s {
+	case "TASK_FINISHED", "TASK_FAILED", "TASK_KILLED", "TASK_LOST",
+		"TASK_ERROR", "TASK_DROPPED", "TASK_GONE", "TASK_GONE_BY_OPERATOR":
+		return true
+	}
+
+	return false
+}
+
+// apply()
+//   Update the state from a single event. Returns true if the
+//   event changed anything that is registered.
+//
+func (ss *streamState) apply(e *state.Event) bool {
+	switch e.Type {
+	case state.EventSubscribed:
+		if e.Subscribed == nil {
+			return false
+		}
+		gs := e.Subscribed.GetState
+
+		ss.frameworks = make(map[string]state.Framework)
+		ss.tasks = make(map[string]state.Task)
+		ss.agents = make(map[string]state.Slave)
+
+		for _, f := range gs.GetFrameworks.Frameworks {
+			ss.addFramework(f.FrameworkInfo)
+		}
+		for _, a := range gs.GetAgents.Agents {
+			ss.addAgent(a)
+		}
+		for _, t := range gs.GetTasks.Tasks {
+			ss.addTask(t)
+		}
+
+		return true
+
+	case state.EventTaskAdded:
+		if e.TaskAdded == nil {
+			return false
+		}
+		ss.addTask(e.TaskAdded.Task)
+
+		return true
+
+	case state.EventTaskUpdated:
+		if e.TaskUpdated == nil {
+			return false
+		}
+		id := e.TaskUpdated.Status.TaskID.Value
+		t, ok := ss.tasks[id]
+		if !ok {
+			log.WithField("task", id).Debug("Update for unknown task")
+			return false
+		}
+
+		if isTerminal(e.TaskUpdated.State) {
+			delete(ss.tasks, id)
+			return true
+		}
+
+		t.State = e.TaskUpdated.State
+		t.Statuses = append(t.Statuses, e.TaskUpdated.Status.ToStatus())
+		ss.tasks[id] = t
+
+		return true
+
+	case state.EventAgentAdded:
+		if e.AgentAdded == nil {
+			return false
+		}
+		ss.addAgent(e.AgentAdded.Agent)
+
+		return true
+
+	case state.EventAgentRemoved:
+		if e.AgentRemoved == nil {
+			return false
+		}
+		delete(ss.agents, e.AgentRemoved.AgentID.Value)
+
+		return true
+
+	case state.EventFrameworkAdded:
+		if e.FrameworkAdded == nil {
+			return false
+		}
+		ss.addFramework(e.FrameworkAdded.Framework.FrameworkInfo)
+
+		return true
+
+	case state.EventFrameworkUpdated:
+		if e.FrameworkUpdated == nil {
+			return false
+		}
+		ss.addFramework(e.FrameworkUpdated.Framework.FrameworkInfo)
+
+		return true
+
+	case state.EventFrameworkRemoved:
+		if e.FrameworkRemoved == nil {
+			return false
+		}
+		id := e.FrameworkRemoved.FrameworkInfo.ID.Value
+		delete(ss.frameworks, id)
+		for tid, t := range ss.tasks {
+			if t.FrameworkID == id {
+				delete(ss.tasks, tid)
+			}
+		}
+
+		return true
+	}
+
+	return false
+}
+
+func (ss *streamState) addFramework(fi state.V1FrameworkInfo) {
+	ss.frameworks[fi.ID.Value] = state.Framework{
+		ID:       fi.ID.Value,
+		Name:     fi.Name,
+		Hostname: fi.Hostname,
+	}
+}
+
+func (ss *streamState) addAgent(a state.V1Agent) {
+	s, err := a.ToSlave()
+	if err != nil {
+		log.WithField("agent", a.AgentInfo.ID.Value).Warn("Invalid agent PID: ", err)
+		return
+	}
+
+	ss.agents[s.ID] = s
+}
+
+func (ss *streamState) addTask(t state.V1Task) {
+	if isTerminal(t.State) {
+		return
+	}
+
+	ss.tasks[t.TaskID.Value] = t.ToTask()
+}
+
+// State()
+//   Return the current state in its /state.json representation
+//
+func (ss *streamState) State() state.State {
+	sj := state.State{
+		Leader: ss.leader,
+	}
+
+	fws := make(map[string]*state.Framework, len(ss.frameworks))
+	for id, f := range ss.frameworks {
+		fw := f
+		fws[id] = &fw
+	}
+
+	ids := make([]string, 0, len(ss.tasks))
+	for id := range ss.tasks {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		t := ss.tasks[id]
+		fw, ok := fws[t.FrameworkID]
+		if !ok {
+			// Task from a framework we haven't seen yet
+			fw = &state.Framework{ID: t.FrameworkID}
+			fws[t.FrameworkID] = fw
+		}
+		fw.Tasks = append(fw.Tasks, t)
+	}
+
+	fwIDs := make([]string, 0, len(fws))
+	for id := range fws {
+		fwIDs = append(fwIDs, id)
+	}
+	sort.Strings(fwIDs)
+
+	for _, id := range fwIDs {
+		sj.Frameworks = append(sj.Frameworks, *fws[id])
+	}
+
+	agentIDs := make([]string, 0, len(ss.agents))
+	for id := range ss.agents {
+		agentIDs = append(agentIDs, id)
+	}
+	sort.Strings(agentIDs)
+
+	for _, id := range agentIDs {
+		sj.Slaves = append(sj.Slaves, ss.agents[id])
+	}
+
+	return sj
+}
@@ -0,0 +1,103 @@
+package mesos
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/mantl/mesos-consul/state"
+)
+
+const subscribedEvent = `{
+  "type": "SUBSCRIBED",
+  "subscribed": {
+    "heartbeat_interval_seconds": 15,
+    "get_state": {
+      "get_frameworks": {"frameworks": [
+        {"framework_info": {"id": {"value": "fw-1"}, "name": "marathon"}, "active": true}
+      ]},
+      "get_agents": {"agents": [
+        {"agent_info": {"id": {"value": "agent-1"}, "hostname": "10.0.0.1", "port": 5051}, "pid": "slave(1)@10.0.0.1:5051"}
+      ]},
+      "get_tasks": {"tasks": [
+        {
+          "name": "web",
+          "task_id": {"value": "web.1"},
+          "framework_id": {"value": "fw-1"},
+          "agent_id": {"value": "agent-1"},
+          "state": "TASK_RUNNING",
+          "resources": [
+            {"name": "cpus", "type": "SCALAR"},
+            {"name": "ports", "type": "RANGES", "ranges": {"range": [{"begin": 31000, "end": 31001}]}}
+          ],
+          "labels": {"labels": [{"key": "tags", "value": "a,b"}]},
+          "statuses": [{"task_id": {"value": "web.1"}, "state": "TASK_RUNNING", "timestamp": 1}]
+        }
+      ]}
+    }
+  }
+}`
+
+func decodeEvent(t *testing.T, s string) *state.Event {
+	e := new(state.Event)
+	if err := json.Unmarshal([]byte(s), e); err != nil {
+		t.Fatalf("Unable to decode event: %s", err)
+	}
+
+	return e
+}
+
+func TestStreamState(t *testing.T) {
+	ss := newStreamState("master@10.0.0.10:5050")
+
+	if !ss.apply(decodeEvent(t, subscribedEvent)) {
+		t.Fatal("SUBSCRIBED should change the state")
+	}
+
+	sj := ss.State()
+	if len(sj.Slaves) != 1 || sj.Slaves[0].PID.Host != "10.0.0.1" {
+		t.Fatalf("unexpected agents: %+v", sj.Slaves)
+	}
+	if len(sj.Frameworks) != 1 || sj.Frameworks[0].Name != "marathon" || len(sj.Frameworks[0].Tasks) != 1 {
+		t.Fatalf("unexpected frameworks: %+v", sj.Frameworks)
+	}
+
+	task := sj.Frameworks[0].Tasks[0]
+	if got := task.Ports(); !sliceEq(got, []string{"31000", "31001"}) {
+		t.Errorf("task ports => %v, want [31000 31001]", got)
+	}
+	if task.Label("tags") != "a,b" {
+		t.Errorf("task tags label => %q, want %q", task.Label("tags"), "a,b")
+	}
+
+	ss.apply(decodeEvent(t, `{"type": "TASK_ADDED", "task_added": {"task": {
+		"name": "db", "task_id": {"value": "db.1"}, "framework_id": {"value": "fw-2"},
+		"agent_id": {"value": "agent-1"}, "state": "TASK_STAGING"}}}`))
+	ss.apply(decodeEvent(t, `{"type": "TASK_UPDATED", "task_updated": {
+		"framework_id": {"value": "fw-2"}, "state": "TASK_RUNNING",
+		"status": {"task_id": {"value": "db.1"}, "state": "TASK_RUNNING", "timestamp": 2}}}`))
+
+	sj = ss.State()
+	if len(sj.Frameworks) != 2 || sj.Frameworks[1].ID != "fw-2" {
+		t.Fatalf("unexpected frameworks: %+v", sj.Frameworks)
+	}
+	if db := sj.Frameworks[1].Tasks[0]; db.State != "TASK_RUNNING" || len(db.Statuses) != 1 {
+		t.Errorf("unexpected task after update: %+v", db)
+	}
+
+	ss.apply(decodeEvent(t, `{"type": "TASK_UPDATED", "task_updated": {
+		"framework_id": {"value": "fw-1"}, "state": "TASK_KILLED",
+		"status": {"task_id": {"value": "web.1"}, "state": "TASK_KILLED"}}}`))
+	ss.apply(decodeEvent(t, `{"type": "AGENT_REMOVED", "agent_removed": {"agent_id": {"value": "agent-1"}}}`))
+
+	sj = ss.State()
+	if len(sj.Slaves) != 0 {
+		t.Errorf("agent not removed: %+v", sj.Slaves)
+	}
+	if len(sj.Frameworks[0].Tasks) != 0 {
+		t.Errorf("killed task not removed: %+v", sj.Frameworks[0].Tasks)
+	}
+
+	if ss.apply(decodeEvent(t, `{"type": "HEARTBEAT"}`)) {
+		t.Error("HEARTBEAT should not change the state")
+	}
+}
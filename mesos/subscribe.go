@@ -0,0 +1,190 @@
+package mesos
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mantl/mesos-consul/state"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// Default heartbeat interval until the master tells us otherwise
+	defaultHeartbeatInterval = 15 * time.Second
+
+	// Number of heartbeats that can be missed before reconnecting
+	missedHeartbeats = 3
+
+	// Delay used to batch bursts of events into a single sync
+	streamSyncDelay = time.Second
+)
+
+var errLeaderChanged = errors.New("leading master changed")
+
+// Subscribe()
+//   Keep the registry in sync using the v1 Operator API SUBSCRIBE
+//   stream of the leading master. Falls back to polling state.json
+//   every refresh interval while the stream is not available.
+//
+func (m *Mesos) Subscribe(refresh time.Duration) {
+	for {
+		err := m.subscribe(refresh)
+		if err == errLeaderChanged {
+			log.Info("Leader changed. Reconnecting event stream")
+			continue
+		}
+
+		log.Warn("Event stream unavailable, polling state.json: ", err)
+		m.Refresh()
+
+		select {
+		case <-m.leaderChan:
+		case <-m.electedChan:
+		case <-time.After(refresh):
+		}
+	}
+}
+
+// subscribe()
+//   Open the event stream and apply events until it fails
+//   or the leader changes
+//
+func (m *Mesos) subscribe(refresh time.Duration) error {
+	// Drain any pending leader change. We are about to connect to the
+	// current leader anyway.
+	select {
+	case <-m.leaderChan:
+	default:
+	}
+
+	mh := m.getLeader()
+	if mh.Ip == "" {
+		return errors.New("No master in zookeeper")
+	}
+
+	api := m.mesosAPI()
+	url := api.url(mh.Ip+":"+mh.PortString, "/api/v1")
+	log.Info("Subscribing to ", url)
+
+	req, err := api.newRequest("POST", url, strings.NewReader(`{"type":"SUBSCRIBE"}`))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	resp, err := api.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("SUBSCRIBE returned %s", resp.Status)
+	}
+
+	events := make(chan *state.Event)
+	errc := make(chan error, 1)
+
+	go func() {
+		rr := newRecordReader(resp.Body)
+		for {
+			record, err := rr.ReadRecord()
+			if err != nil {
+				errc <- err
+				return
+			}
+
+			e := new(state.Event)
+			if err := json.Unmarshal(record, e); err != nil {
+				errc <- err
+				return
+			}
+
+			select {
+			case events <- e:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	ss := newStreamState(fmt.Sprintf("master@%s:%s", mh.Ip, mh.PortString))
+	subscribed := false
+	synced := false
+	heartbeat := defaultHeartbeatInterval
+	lastEvent := time.Now()
+
+	watchdog := time.NewTicker(time.Second)
+	defer watchdog.Stop()
+
+	var pending <-chan time.Time
+
+	// The syncs follow the events. Sync every refresh interval too,
+	// or on a quiet cluster the deregistration grace never runs out
+	// and the TTL of the Mesos health checks expires.
+	resync := time.NewTicker(refresh)
+	defer resync.Stop()
+
+	for {
+		select {
+		case e := <-events:
+			lastEvent = time.Now()
+			log.Debugf("Received %s event", e.Type)
+
+			if e.Type == state.EventSubscribed && e.Subscribed != nil {
+				subscribed = true
+				if e.Subscribed.HeartbeatIntervalSeconds > 0 {
+					heartbeat = time.Duration(e.Subscribed.HeartbeatIntervalSeconds * float64(time.Second))
+				}
+			}
+
+			if ss.apply(e) && subscribed && pending == nil {
+				pending = time.After(streamSyncDelay)
+			}
+
+			// On a quiet cluster, the heartbeats are all that tells
+			// the registry is still in sync
+			if e.Type == state.EventHeartbeat && synced && pending == nil {
+				m.streamAlive()
+			}
+
+		case <-pending:
+			pending = nil
+			m.syncState(ss.State())
+			synced = true
+
+		case <-resync.C:
+			if subscribed && pending == nil {
+				m.syncState(ss.State())
+			}
+
+		case <-m.electedChan:
+			if subscribed {
+				pending = nil
+				m.syncState(ss.State())
+				synced = true
+			}
+
+		case err := <-errc:
+			return err
+
+		case <-m.leaderChan:
+			return errLeaderChanged
+
+		case <-watchdog.C:
+			if time.Since(lastEvent) > missedHeartbeats*heartbeat {
+				return errors.New("missed heartbeats from master")
+			}
+		}
+	}
+}
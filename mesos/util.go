@@ -47,8 +47,11 @@ func sliceContainsString(s []string, b string) bool {
 }
 
 func leaderIP(leader string) string {
-	host := strings.Split(leader, "@")[1]
-	host = strings.Split(host, ":")[0]
+	parts := strings.SplitN(leader, "@", 2)
+	if len(parts) != 2 {
+		return ""
+	}
+	host := strings.Split(parts[1], ":")[0]
 
 	return toIP(host)
 }
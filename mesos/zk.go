@@ -2,23 +2,89 @@ package mesos
 
 import (
 	"encoding/binary"
+	"encoding/json"
 	"fmt"
 	"net"
+	"net/url"
+	"sort"
+	"strings"
 	"time"
 
-	"github.com/mesos/mesos-go/detector"
-	_ "github.com/mesos/mesos-go/detector/zoo"
+	"github.com/mantl/mesos-consul/config"
+	"github.com/mantl/mesos-consul/metrics"
+
+	protobuf "github.com/gogo/protobuf/proto"
 	proto "github.com/mesos/mesos-go/mesosproto"
+	"github.com/samuel/go-zookeeper/zk"
 	log "github.com/sirupsen/logrus"
 )
 
+const (
+	// Time to wait for the first leader at start
+	initialLeaderWait = 2 * time.Minute
+
+	// Delay before reading the masters again after an error
+	zkRetryDelay = time.Second
+
+	// Znodes of the masters, holding their MasterInfo in JSON
+	masterNodePrefix = "json.info_"
+
+	// Znodes of the masters older than Mesos 0.24, holding their
+	// MasterInfo in protobuf
+	protoMasterNodePrefix = "info_"
+)
+
+// zkMaster is the MasterInfo of a master znode
+type zkMaster struct {
+	ID       string `json:"id"`
+	Hostname string `json:"hostname"`
+	IP       uint32 `json:"ip"`
+	Port     uint32 `json:"port"`
+	PID      string `json:"pid"`
+	Address  struct {
+		Hostname string `json:"hostname"`
+		IP       string `json:"ip"`
+		Port     int32  `json:"port"`
+	} `json:"address"`
+}
+
+func (zm *zkMaster) masterInfo() *proto.MasterInfo {
+	mi := &proto.MasterInfo{
+		Id:       &zm.ID,
+		Hostname: &zm.Hostname,
+		Ip:       &zm.IP,
+		Port:     &zm.Port,
+		Pid:      &zm.PID,
+	}
+	if zm.Address.IP != "" || zm.Address.Hostname != "" {
+		mi.Address = &proto.Address{
+			Hostname: &zm.Address.Hostname,
+			Ip:       &zm.Address.IP,
+			Port:     &zm.Address.Port,
+		}
+	}
+
+	return mi
+}
+
 func (m *Mesos) OnMasterChanged(leader *proto.MasterInfo) {
 	m.Lock.Lock()
 	defer m.Lock.Unlock()
 
 	m.started.Do(func() { close(m.startChan) })
 
+	if leader == nil {
+		log.Warn("No leading master")
+	}
+
 	m.Leader = leader
+	metrics.LeaderChanges.Inc()
+
+	// Let the event stream know it has to reconnect
+	select {
+	case m.leaderChan <- struct{}{}:
+	default:
+	}
 }
 
 func (m *Mesos) UpdatedMasters(masters []*proto.MasterInfo) {
@@ -28,26 +94,278 @@ func (m *Mesos) UpdatedMasters(masters []*proto.MasterInfo) {
 	m.Masters = masters
 }
 
-func (m *Mesos) zkDetector(zkURI string) {
-	if zkURI == "" {
-		log.Fatal("Zookeeper address not provided")
+// updateMasters()
+//   Record the masters, and the leader if it changed. A nil leader
+//   means that no master is leading.
+//
+func (m *Mesos) updateMasters(leader *proto.MasterInfo, masters []*proto.MasterInfo) {
+	m.Lock.Lock()
+	changed := m.Leader.GetId() != leader.GetId()
+	m.Lock.Unlock()
+
+	if changed {
+		if leader != nil {
+			mh := MasterInfoToMesosHost(leader)
+			log.Infof("Leading master: %s:%s", mh.Ip, mh.PortString)
+		}
+		m.OnMasterChanged(leader)
 	}
 
-	log.WithField("zk", zkURI).Debug("Zookeeper address")
-	md, err := detector.New(zkURI)
+	m.UpdatedMasters(masters)
+	m.started.Do(func() { close(m.startChan) })
+}
+
+// zkDetector()
+//   Follow the masters and the leader in Zookeeper. Waits a while
+//   for the first leader, then goes on without one.
+//
+func (m *Mesos) zkDetector(c *config.Config) {
+	servers, path, err := zkURL(c.Zk)
 	if err != nil {
-		log.Fatal(err.Error())
+		log.Fatal(err)
+	}
+
+	log.WithField("zk", c.Zk).Debug("Zookeeper address")
+
+	dialer := func(network, address string, _ time.Duration) (net.Conn, error) {
+		return net.DialTimeout(network, address, c.ZkConnectTimeout)
+	}
+	conn, session, err := zk.Connect(servers, c.ZkSessionTimeout, zk.WithDialer(dialer))
+	if err != nil {
+		log.Fatal(err)
 	}
 
 	m.startChan = make(chan struct{})
-	md.Detect(m)
+	m.leaderChan = make(chan struct{}, 1)
+	m.Lock.Lock()
+	m.zkConnecting = true
+	m.Lock.Unlock()
+
+	reread := make(chan struct{}, 1)
+	go m.watchSession(session, reread)
+	go m.watchMasters(conn, path, c.ZkAuth, reread)
 
 	select {
 	case <-m.startChan:
 		log.Info("Done waiting for initial leader information from Zookeeper.")
-	case <-time.After(2 * time.Minute):
-		log.Fatal("Timed out waiting for initial ZK detection.")
+	case <-time.After(initialLeaderWait):
+		log.Warn("No leader information from Zookeeper yet, starting without it")
+	}
+}
+
+// zkURL()
+//   Split a zk://host:port,host:port/path URL
+//
+func zkURL(uri string) ([]string, string, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, "", err
+	}
+	if u.Scheme != "zk" || u.Host == "" || u.Path == "" {
+		return nil, "", fmt.Errorf("invalid Zookeeper URL '%s'", uri)
+	}
+
+	return strings.Split(u.Host, ","), strings.TrimSuffix(u.Path, "/"), nil
+}
+
+// watchSession()
+//   Follow the Zookeeper session. The masters are read again once
+//   the session is back, as changes may have been missed.
+//
+func (m *Mesos) watchSession(session <-chan zk.Event, reread chan<- struct{}) {
+	for ev := range session {
+		switch ev.State {
+		case zk.StateHasSession:
+			select {
+			case reread <- struct{}{}:
+			default:
+			}
+		case zk.StateDisconnected:
+			m.setZkDegraded("disconnected from Zookeeper")
+		case zk.StateExpired:
+			m.setZkDegraded("Zookeeper session expired")
+		case zk.StateAuthFailed:
+			m.setZkDegraded("Zookeeper authentication failed")
+		}
+	}
+}
+
+// watchMasters()
+//   Read the masters every time they change in Zookeeper
+//
+func (m *Mesos) watchMasters(conn *zk.Conn, path, auth string, reread <-chan struct{}) {
+	authenticated := auth == ""
+
+	for {
+		if !authenticated {
+			if err := conn.AddAuth("digest", []byte(auth)); err != nil {
+				m.setZkDegraded("Zookeeper authentication failed: " + err.Error())
+				time.Sleep(zkRetryDelay)
+				continue
+			}
+			authenticated = true
+		}
+
+		masters, watch, err := readMasters(conn, path)
+		if err != nil {
+			m.setZkDegraded(err.Error())
+			time.Sleep(zkRetryDelay)
+			continue
+		}
+
+		var leader *proto.MasterInfo
+		if len(masters) > 0 {
+			leader = masters[0]
+		}
+		m.updateMasters(leader, masters)
+		m.setZkDegraded("")
+
+		select {
+		case <-watch:
+		case <-reread:
+		}
+	}
+}
+
+// readMasters()
+//   Read the masters under path, the leader first, and watch for
+//   changes. The leader is the master with the lowest sequence
+//   number.
+//
+func readMasters(conn *zk.Conn, path string) ([]*proto.MasterInfo, <-chan zk.Event, error) {
+	children, _, watch, err := conn.ChildrenW(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %s", path, err)
+	}
+
+	nodes := masterNodes(children)
+
+	masters := make([]*proto.MasterInfo, 0, len(nodes))
+	for _, node := range nodes {
+		data, _, err := conn.Get(path + "/" + node)
+		if err == zk.ErrNoNode {
+			// The master went away since we listed it
+			continue
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s/%s: %s", path, node, err)
+		}
+
+		mi, err := parseMaster(node, data)
+		if err != nil {
+			log.Warnf("Ignoring master %s/%s: %s", path, node, err)
+			continue
+		}
+		masters = append(masters, mi)
+	}
+
+	return masters, watch, nil
+}
+
+// masterNodes()
+//   Return the master znodes out of children, sorted. The masters
+//   write JSON nodes since Mesos 0.24, and protobuf nodes before
+//   or next to them. The JSON nodes are used when there are any.
+//
+func masterNodes(children []string) []string {
+	var nodes, protoNodes []string
+	for _, child := range children {
+		switch {
+		case strings.HasPrefix(child, masterNodePrefix):
+			nodes = append(nodes, child)
+		case strings.HasPrefix(child, protoMasterNodePrefix):
+			protoNodes = append(protoNodes, child)
+		}
+	}
+
+	if len(nodes) == 0 {
+		nodes = protoNodes
+	}
+	sort.Strings(nodes)
+
+	return nodes
+}
+
+// parseMaster()
+//   Decode the MasterInfo of a master znode
+//
+func parseMaster(node string, data []byte) (*proto.MasterInfo, error) {
+	if strings.HasPrefix(node, protoMasterNodePrefix) {
+		mi := new(proto.MasterInfo)
+		if err := protobuf.Unmarshal(data, mi); err != nil {
+			return nil, err
+		}
+
+		return mi, nil
+	}
+
+	var zm zkMaster
+	if err := json.Unmarshal(data, &zm); err != nil {
+		return nil, err
 	}
+
+	return zm.masterInfo(), nil
+}
+
+// setZkDegraded()
+//   Record why Zookeeper can't be trusted, or that it can again
+//   when reason is empty. The masters known may be stale while
+//   degraded, so deregistrations are paused. Either way, the
+//   connection is no longer starting.
+//
+func (m *Mesos) setZkDegraded(reason string) {
+	m.Lock.Lock()
+	defer m.Lock.Unlock()
+
+	was := m.zkDegraded
+	m.zkDegraded = reason
+	m.zkConnecting = false
+
+	switch {
+	case reason != "" && was == "":
+		log.Warn("Zookeeper degraded, pausing deregistrations: ", reason)
+		metrics.ZkDegraded.Set(1)
+	case reason == "" && was != "":
+		log.Info("Zookeeper recovered, resuming deregistrations")
+		metrics.ZkDegraded.Set(0)
+	}
+}
+
+// ZkDegraded()
+//   Return why Zookeeper is degraded, or an empty string when it
+//   isn't or isn't used
+//
+func (m *Mesos) ZkDegraded() string {
+	m.Lock.Lock()
+	defer m.Lock.Unlock()
+
+	return m.zkDegraded
+}
+
+// ZkConnecting()
+//   Return whether the masters weren't read from Zookeeper yet
+//
+func (m *Mesos) ZkConnecting() bool {
+	m.Lock.Lock()
+	defer m.Lock.Unlock()
+
+	return m.zkConnecting
+}
+
+// zkPaused()
+//   Return why deregistrations are paused, or an empty string when
+//   the masters known can be trusted. Nothing was read while still
+//   connecting, which isn't degraded, but isn't trusted either.
+//
+func (m *Mesos) zkPaused() string {
+	m.Lock.Lock()
+	defer m.Lock.Unlock()
+
+	if m.zkConnecting {
+		return "still connecting to Zookeeper"
+	}
+
+	return m.zkDegraded
 }
 
 // Get the leader out of the list of masters
@@ -66,7 +384,7 @@ func (m *Mesos) getMasters() []*MesosHost {
 	ms := make([]*MesosHost, len(m.Masters))
 	for i, msp := range m.Masters {
 		mh := MasterInfoToMesosHost(msp)
-		if *m.Leader.Id == *msp.Id {
+		if m.Leader != nil && m.Leader.GetId() == msp.GetId() {
 			mh.IsLeader = true
 		}
 
@@ -0,0 +1,195 @@
+package mesos
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/mantl/mesos-consul/registry"
+	"github.com/mantl/mesos-consul/state"
+
+	protobuf "github.com/gogo/protobuf/proto"
+	proto "github.com/mesos/mesos-go/mesosproto"
+)
+
+const masterNodeJSON = `{
+  "address": {"hostname": "master1", "ip": "10.0.0.10", "port": 5050},
+  "hostname": "master1",
+  "id": "8b1e2c43-master1",
+  "ip": 167772170,
+  "pid": "master@10.0.0.10:5050",
+  "port": 5050,
+  "version": "1.4.0"
+}`
+
+func TestZkMaster(t *testing.T) {
+	var zm zkMaster
+	if err := json.Unmarshal([]byte(masterNodeJSON), &zm); err != nil {
+		t.Fatal(err)
+	}
+
+	mi := zm.masterInfo()
+	if mi.GetId() != "8b1e2c43-master1" {
+		t.Errorf("id = %s", mi.GetId())
+	}
+
+	mh := MasterInfoToMesosHost(mi)
+	if mh.Host != "master1" || mh.Ip != "10.0.0.10" || mh.PortString != "5050" {
+		t.Errorf("MasterInfoToMesosHost() = %+v", mh)
+	}
+}
+
+func TestMasterNodes(t *testing.T) {
+	for _, tt := range []struct {
+		children []string
+		want     []string
+	}{
+		{
+			[]string{"log_replicas", "json.info_0000000002", "info_0000000001", "json.info_0000000001", "info_0000000002"},
+			[]string{"json.info_0000000001", "json.info_0000000002"},
+		},
+		{
+			[]string{"log_replicas", "info_0000000002", "info_0000000001"},
+			[]string{"info_0000000001", "info_0000000002"},
+		},
+		{[]string{"log_replicas"}, nil},
+	} {
+		if got := masterNodes(tt.children); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("masterNodes(%v) = %v, want %v", tt.children, got, tt.want)
+		}
+	}
+}
+
+func TestParseProtobufMaster(t *testing.T) {
+	id, hostname := "8b1e2c43-master1", "10.0.0.10"
+	ip, port := uint32(167772170), uint32(5050)
+	data, err := protobuf.Marshal(&proto.MasterInfo{Id: &id, Hostname: &hostname, Ip: &ip, Port: &port})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mi, err := parseMaster("info_0000000001", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mi.GetId() != id {
+		t.Errorf("id = %s", mi.GetId())
+	}
+
+	mh := MasterInfoToMesosHost(mi)
+	if mh.Ip != "10.0.0.10" || mh.PortString != "5050" {
+		t.Errorf("MasterInfoToMesosHost() = %+v", mh)
+	}
+}
+
+func TestGetMastersWithoutLeader(t *testing.T) {
+	m := newTestMesos(t, &registry.Recorder{})
+	m.startChan = make(chan struct{})
+	m.leaderChan = make(chan struct{}, 1)
+
+	var zm zkMaster
+	if err := json.Unmarshal([]byte(masterNodeJSON), &zm); err != nil {
+		t.Fatal(err)
+	}
+	masters := []*proto.MasterInfo{zm.masterInfo()}
+
+	m.updateMasters(masters[0], masters)
+	if ms := m.getMasters(); len(ms) != 1 || !ms[0].IsLeader {
+		t.Errorf("getMasters() = %+v, want the leader", ms)
+	}
+
+	// The leader lost its election and no other master took over
+	m.updateMasters(nil, masters)
+	if m.HasLeader() {
+		t.Error("HasLeader() is true without a leader")
+	}
+	if ms := m.getMasters(); len(ms) != 1 || ms[0].IsLeader {
+		t.Errorf("getMasters() = %+v, want no leader", ms)
+	}
+}
+
+// sweepRegistry holds its services in a registry cache, and records
+// the services it removes
+type sweepRegistry struct {
+	registry.Cache
+	removed []string
+}
+
+func (r *sweepRegistry) CacheLoad(host, prefix string) error { return nil }
+func (r *sweepRegistry) Register(s *registry.Service)        { r.CacheAdd(s, "") }
+
+func (r *sweepRegistry) Deregister() {
+	r.CacheSweep(func(cs *registry.CachedService) bool {
+		r.removed = append(r.removed, cs.Service.ID)
+		return true
+	})
+}
+
+func TestZkDegraded(t *testing.T) {
+	var sj state.State
+	if err := json.Unmarshal([]byte(masterStateJSON), &sj); err != nil {
+		t.Fatal(err)
+	}
+
+	r := &sweepRegistry{Cache: registry.NewCache("test")}
+	r.CacheCreate()
+	b := registry.NewBreaker(r, 50, 0, 0)
+	m := newTestMesos(t, b)
+
+	m.parseState(sj)
+	managed := len(r.CacheIDs())
+	if managed == 0 {
+		t.Fatal("nothing registered")
+	}
+
+	// The masters from Zookeeper can't be trusted, nor their state
+	m.setZkDegraded("Zookeeper session expired")
+	for i := 0; i < 3; i++ {
+		m.parseState(state.State{})
+	}
+	if len(r.removed) != 0 || len(r.CacheIDs()) != managed {
+		t.Errorf("removed %v while Zookeeper is degraded", r.removed)
+	}
+	if held := b.Held(); len(held) != 0 {
+		t.Errorf("the breaker held %v while Zookeeper is degraded", held)
+	}
+
+	m.setZkDegraded("")
+	m.parseState(sj)
+	m.parseState(state.State{})
+	if held := b.Held(); len(held) != managed {
+		t.Errorf("held => %v once Zookeeper recovered, want %d services", held, managed)
+	}
+}
+
+func TestZkConnecting(t *testing.T) {
+	var sj state.State
+	if err := json.Unmarshal([]byte(masterStateJSON), &sj); err != nil {
+		t.Fatal(err)
+	}
+
+	r := &sweepRegistry{Cache: registry.NewCache("test")}
+	r.CacheCreate()
+	m := newTestMesos(t, r)
+
+	m.parseState(sj)
+	managed := len(r.CacheIDs())
+
+	// Starting isn't degraded, but nothing was read to trust yet
+	m.zkConnecting = true
+	if m.ZkDegraded() != "" {
+		t.Errorf("ZkDegraded() => %q while connecting", m.ZkDegraded())
+	}
+	for i := 0; i < 3; i++ {
+		m.parseState(state.State{})
+	}
+	if len(r.removed) != 0 || len(r.CacheIDs()) != managed {
+		t.Errorf("removed %v while connecting to Zookeeper", r.removed)
+	}
+
+	// Reading the masters ends it
+	m.setZkDegraded("")
+	if m.ZkConnecting() {
+		t.Error("still connecting once the masters were read")
+	}
+}
@@ -0,0 +1,213 @@
+// Package metrics holds the Prometheus metrics of the sync loop.
+package metrics
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "mesos_consul"
+
+// Refresh outcomes
+const (
+	OutcomeSuccess    = "success"
+	OutcomeFetchError = "fetch_error"
+	OutcomeNoLeader   = "no_leader"
+)
+
+var (
+	RefreshDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "refresh_duration_seconds",
+		Help:      "Time taken by a refresh, by outcome.",
+		Buckets:   []float64{.05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60},
+	}, []string{"outcome"})
+
+	StateFetchDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "state_fetch_duration_seconds",
+		Help:      "Time taken to fetch and decode state.json from a master.",
+		Buckets:   []float64{.01, .05, .1, .25, .5, 1, 2.5, 5, 10},
+	})
+
+	StateFetchBytes = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "state_fetch_bytes",
+		Help:      "Size of the last state.json fetched from a master.",
+	})
+
+	StateFetchErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "state_fetch_errors_total",
+		Help:      "Failed state fetches, by kind: network, status or decode.",
+	}, []string{"kind"})
+
+	ServicesRegistered = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "services_registered_total",
+		Help:      "Services registered or updated.",
+	}, []string{"registry", "agent", "framework"})
+
+	ServicesSkipped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "services_skipped_total",
+		Help:      "Services left alone because they were unchanged.",
+	}, []string{"registry", "agent", "framework"})
+
+	ServicesDeregistered = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "services_deregistered_total",
+		Help:      "Services deregistered.",
+	}, []string{"registry", "agent"})
+
+	RegistrationErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "registration_errors_total",
+		Help:      "Failed registrations and deregistrations, by agent.",
+	}, []string{"registry", "agent"})
+
+	ReconcileReregistered = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "reconcile_reregistered_total",
+		Help:      "Services registered again by reconciliation because their agent lost them.",
+	}, []string{"registry"})
+
+	ReconcileRemoved = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "reconcile_removed_total",
+		Help:      "Unmanaged services carrying the service ID prefix removed by reconciliation.",
+	}, []string{"registry"})
+
+	ReconcileErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "reconcile_errors_total",
+		Help:      "Failed agent calls during reconciliation.",
+	}, []string{"registry"})
+
+	CacheSize = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "cache_size",
+		Help:      "Services in the registry cache.",
+	}, []string{"registry"})
+
+	ServicesInGrace = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "services_in_grace",
+		Help:      "Services missing from Mesos but kept for their deregistration grace.",
+	}, []string{"registry"})
+
+	Tasks = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "tasks",
+		Help:      "Running tasks seen in the last sync, by framework.",
+	}, []string{"framework"})
+
+	LeaderChanges = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "leader_changes_total",
+		Help:      "Leading master changes seen in Zookeeper.",
+	})
+
+	ZkDegraded = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "zk_degraded",
+		Help:      "1 while the Zookeeper session is lost and deregistrations are paused.",
+	})
+
+	DeregistrationsHeld = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "deregistrations_held",
+		Help:      "Deregistrations held by the mass-deregistration breaker.",
+	})
+
+	HALeader = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "ha_leader",
+		Help:      "1 if this instance is the active one with --ha, 0 otherwise.",
+	})
+
+	HATransitions = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ha_transitions_total",
+		Help:      "Leadership gained or lost with --ha.",
+	})
+
+	LastSync = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "last_sync_timestamp_seconds",
+		Help:      "Time of the last successful sync.",
+	})
+)
+
+var (
+	lastSyncLock sync.Mutex
+	lastSync     time.Time
+)
+
+func init() {
+	prometheus.MustRegister(
+		RefreshDuration,
+		StateFetchDuration,
+		StateFetchBytes,
+		StateFetchErrors,
+		ServicesRegistered,
+		ServicesSkipped,
+		ServicesDeregistered,
+		RegistrationErrors,
+		ReconcileReregistered,
+		ReconcileRemoved,
+		ReconcileErrors,
+		CacheSize,
+		ServicesInGrace,
+		Tasks,
+		LeaderChanges,
+		ZkDegraded,
+		DeregistrationsHeld,
+		HALeader,
+		HATransitions,
+		LastSync,
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "last_sync_age_seconds",
+			Help:      "Seconds since the last successful sync, or -1 before the first one.",
+		}, lastSyncAge),
+	)
+}
+
+// Synced records a successful sync
+func Synced() {
+	now := time.Now()
+
+	lastSyncLock.Lock()
+	lastSync = now
+	lastSyncLock.Unlock()
+
+	LastSync.Set(float64(now.UnixNano()) / 1e9)
+}
+
+// LastSyncTime returns the time of the last successful sync, or the
+// zero time before the first one
+func LastSyncTime() time.Time {
+	lastSyncLock.Lock()
+	defer lastSyncLock.Unlock()
+
+	return lastSync
+}
+
+func lastSyncAge() float64 {
+	t := LastSyncTime()
+	if t.IsZero() {
+		return -1
+	}
+
+	return time.Since(t).Seconds()
+}
+
+// Handler serves the metrics
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
@@ -0,0 +1,35 @@
+package metrics
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	ServicesRegistered.WithLabelValues("consul", "10.0.0.1", "marathon").Inc()
+	RegistrationErrors.WithLabelValues("consul", "10.0.0.2").Inc()
+
+	if age := lastSyncAge(); age != -1 {
+		t.Errorf("age before the first sync => %v, want -1", age)
+	}
+	Synced()
+	if age := lastSyncAge(); age < 0 || age > 1 {
+		t.Errorf("age after a sync => %v", age)
+	}
+
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := ioutil.ReadAll(rec.Body)
+
+	for _, want := range []string{
+		`mesos_consul_services_registered_total{agent="10.0.0.1",framework="marathon",registry="consul"} 1`,
+		`mesos_consul_registration_errors_total{agent="10.0.0.2",registry="consul"} 1`,
+		`mesos_consul_last_sync_age_seconds `,
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("metrics missing %q", want)
+		}
+	}
+}
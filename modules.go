@@ -2,4 +2,6 @@ package main
 
 import (
 	_ "github.com/mantl/mesos-consul/consul"
+	_ "github.com/mantl/mesos-consul/etcd"
+	_ "github.com/mantl/mesos-consul/filesd"
 )
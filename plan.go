@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/mantl/mesos-consul/mesos"
+	"github.com/mantl/mesos-consul/registry"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Exit codes of the plan command
+const (
+	planInSync  = 0
+	planError   = 1
+	planChanges = 2
+)
+
+// planOutput is the JSON output of the plan command
+type planOutput struct {
+	Add     int                    `json:"add"`
+	Change  int                    `json:"change"`
+	Remove  int                    `json:"remove"`
+	Changes []registry.ServiceDiff `json:"changes"`
+}
+
+// runPlan prints the changes a sync would make and returns the exit code
+func runPlan(args []string) int {
+	c, err := parseFlags(args)
+	if err != nil {
+		log.Error(err)
+		return planError
+	}
+
+	// A plan never writes, and must not win the election
+	c.HA = false
+	c.DryRun = true
+
+	logSource(c)
+	m := mesos.New(c)
+
+	diffs, err := m.Plan()
+	if err != nil {
+		log.Error(err)
+		return planError
+	}
+
+	if c.PlanFormat == "json" {
+		err = writePlanJSON(os.Stdout, diffs)
+	} else {
+		err = writePlanText(os.Stdout, diffs)
+	}
+	if err != nil {
+		log.Error(err)
+		return planError
+	}
+
+	if len(diffs) > 0 {
+		return planChanges
+	}
+
+	return planInSync
+}
+
+func newPlanOutput(diffs []registry.ServiceDiff) planOutput {
+	out := planOutput{Changes: diffs}
+	if out.Changes == nil {
+		out.Changes = []registry.ServiceDiff{}
+	}
+
+	for _, d := range diffs {
+		switch d.Action {
+		case registry.DiffAdd:
+			out.Add++
+		case registry.DiffChange:
+			out.Change++
+		case registry.DiffRemove:
+			out.Remove++
+		}
+	}
+
+	return out
+}
+
+func writePlanJSON(w io.Writer, diffs []registry.ServiceDiff) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(newPlanOutput(diffs))
+}
+
+var planSymbols = map[string]string{
+	registry.DiffAdd:    "+",
+	registry.DiffChange: "~",
+	registry.DiffRemove: "-",
+}
+
+func writePlanText(w io.Writer, diffs []registry.ServiceDiff) error {
+	var b bytes.Buffer
+
+	if len(diffs) == 0 {
+		fmt.Fprintln(&b, "No changes. The registry is in sync with Mesos.")
+		_, err := b.WriteTo(w)
+		return err
+	}
+
+	agent := ""
+	for i, d := range diffs {
+		if i == 0 || d.Agent != agent {
+			if i > 0 {
+				fmt.Fprintln(&b)
+			}
+			agent = d.Agent
+			fmt.Fprintf(&b, "Agent %s:\n", agent)
+		}
+
+		fmt.Fprintf(&b, "  %s %s\n", planSymbols[d.Action], d.ID)
+
+		switch d.Action {
+		case registry.DiffAdd:
+			s := d.Service
+			fmt.Fprintf(&b, "      name:    %s\n", s.Name)
+			fmt.Fprintf(&b, "      address: %s\n", s.Address)
+			fmt.Fprintf(&b, "      port:    %d\n", s.Port)
+			if len(s.Tags) > 0 {
+				fmt.Fprintf(&b, "      tags:    %s\n", strings.Join(s.Tags, ","))
+			}
+		case registry.DiffChange:
+			for _, f := range d.Fields {
+				fmt.Fprintf(&b, "      %s: %q => %q\n", f.Field, f.Old, f.New)
+			}
+		}
+	}
+
+	out := newPlanOutput(diffs)
+	fmt.Fprintf(&b, "\nPlan: %d to add, %d to change, %d to remove.\n", out.Add, out.Change, out.Remove)
+
+	_, err := b.WriteTo(w)
+	return err
+}
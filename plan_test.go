@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/mantl/mesos-consul/registry"
+)
+
+func TestWritePlan(t *testing.T) {
+	diffs := registry.Diff(
+		[]*registry.Service{
+			{ID: "p:web", Name: "web", Port: 80, Address: "10.0.0.1", Agent: "10.0.0.1"},
+			{ID: "p:db", Name: "db", Port: 5432, Address: "10.0.0.2", Agent: "10.0.0.2"},
+		},
+		[]*registry.Service{
+			{ID: "p:db", Name: "db", Port: 5433, Address: "10.0.0.2", Agent: "10.0.0.2"},
+			{ID: "p:old", Name: "old", Port: 81, Address: "10.0.0.2", Agent: "10.0.0.2"},
+		},
+	)
+
+	var b bytes.Buffer
+	if err := writePlanText(&b, diffs); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"Agent 10.0.0.1:\n  + p:web\n",
+		"Agent 10.0.0.2:\n  ~ p:db\n      port: \"5433\" => \"5432\"\n  - p:old\n",
+		"Plan: 1 to add, 1 to change, 1 to remove.",
+	} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("text output missing %q:\n%s", want, b.String())
+		}
+	}
+
+	b.Reset()
+	if err := writePlanJSON(&b, diffs); err != nil {
+		t.Fatal(err)
+	}
+	var out planOutput
+	if err := json.Unmarshal(b.Bytes(), &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Add != 1 || out.Change != 1 || out.Remove != 1 || len(out.Changes) != 3 {
+		t.Errorf("JSON output => %+v", out)
+	}
+
+	b.Reset()
+	writePlanText(&b, nil)
+	if !strings.HasPrefix(b.String(), "No changes.") {
+		t.Errorf("empty plan => %q", b.String())
+	}
+}
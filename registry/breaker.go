@@ -0,0 +1,299 @@
+package registry
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/mantl/mesos-consul/metrics"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Breaker protects a registry against mass deregistration. When a
+// cycle leaves out more managed services than the limits allow, for
+// example because the master returned a state without frameworks, the
+// missing services are kept and an error is reported until either the
+// condition held for a number of consecutive cycles or an operator
+// released the breaker.
+type Breaker struct {
+	Registry
+
+	maxPercent    int
+	maxCount      int
+	confirmCycles int
+
+	// Services registered in the current and in the last cycle
+	seen     map[string]bool
+	lastSeen map[string]bool
+
+	// Deregistrations held in the last cycle, consecutive cycles
+	// that tripped the breaker, and operator release
+	lock     sync.Mutex
+	held     []string
+	cycles   int
+	released bool
+
+	// Services that tripped the breaker and were not removed yet.
+	// The cycles and the release count until they are gone.
+	tripped map[string]bool
+}
+
+// NewBreaker wraps r. A cycle trips the breaker when it would remove
+// more than maxPercent percent or more than maxCount of the managed
+// services. A limit of 0 is disabled.
+func NewBreaker(r Registry, maxPercent, maxCount, confirmCycles int) *Breaker {
+	return &Breaker{
+		Registry:      r,
+		maxPercent:    maxPercent,
+		maxCount:      maxCount,
+		confirmCycles: confirmCycles,
+		seen:          make(map[string]bool),
+	}
+}
+
+func (b *Breaker) Register(s *Service) {
+	b.seen[s.ID] = true
+	b.Registry.Register(s)
+}
+
+// CacheMark keeps the service like a registration does
+func (b *Breaker) CacheMark(id string) {
+	b.seen[id] = true
+	b.Registry.CacheMark(id)
+}
+
+// Deregister holds the deregistrations when there are too many of them
+func (b *Breaker) Deregister() {
+	managed := b.managed()
+	missing := b.missing(managed)
+
+	b.lastSeen = b.seen
+	b.seen = make(map[string]bool)
+
+	if b.hold(missing, len(managed)) {
+		// Keep the missing services this cycle. Their grace is
+		// over and stays over, so they are missing again next cycle.
+		for _, id := range missing {
+			b.CacheHold(id)
+		}
+	}
+
+	b.Registry.Deregister()
+}
+
+// hold decides whether the deregistrations of this cycle are held
+func (b *Breaker) hold(missing []string, managed int) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if !b.exceeds(len(missing), managed) {
+		b.held = nil
+		metrics.DeregistrationsHeld.Set(0)
+
+		if !b.stillTripped(missing) {
+			if b.cycles > 0 {
+				log.Warn("Deregistration breaker closed")
+			}
+			b.cycles = 0
+			b.released = false
+			b.tripped = nil
+		}
+		return false
+	}
+
+	if b.tripped == nil {
+		b.tripped = make(map[string]bool)
+	}
+	for _, id := range missing {
+		b.tripped[id] = true
+	}
+
+	if b.released {
+		b.held = nil
+		metrics.DeregistrationsHeld.Set(0)
+		return false
+	}
+
+	b.cycles++
+	if b.confirmCycles > 0 && b.cycles > b.confirmCycles {
+		log.Warnf("Deregistration breaker: %d of %d services missing for %d cycles. Deregistering",
+			len(missing), managed, b.confirmCycles)
+		b.released = true
+		b.held = nil
+		metrics.DeregistrationsHeld.Set(0)
+		return false
+	}
+
+	b.held = missing
+	log.Errorf("Deregistration breaker: holding the deregistration of %d of %d services (cycle %d of %d). "+
+		"Release it with POST /v1/breaker/release (--deregister-release-api) if this is expected", len(missing), managed, b.cycles, b.confirmCycles)
+	metrics.DeregistrationsHeld.Set(float64(len(missing)))
+
+	return true
+}
+
+// stillTripped returns true while services that tripped the breaker
+// are about to be removed
+func (b *Breaker) stillTripped(missing []string) bool {
+	for _, id := range missing {
+		if b.tripped[id] {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (b *Breaker) exceeds(missing, managed int) bool {
+	if b.maxCount > 0 && missing > b.maxCount {
+		return true
+	}
+	if b.maxPercent > 0 && managed > 0 && missing*100 > b.maxPercent*managed {
+		return true
+	}
+
+	return false
+}
+
+// missing returns the IDs of the services the registry is about to
+// remove. Services in their grace period are left out when the
+// registry can tell them apart.
+func (b *Breaker) missing(managed map[string]bool) []string {
+	var missing []string
+
+	if e, ok := b.Registry.(Expirer); ok {
+		for _, id := range e.Expiring() {
+			if !b.seen[id] {
+				missing = append(missing, id)
+			}
+		}
+	} else {
+		for id := range managed {
+			if !b.seen[id] {
+				missing = append(missing, id)
+			}
+		}
+	}
+	sort.Strings(missing)
+
+	return missing
+}
+
+// managed returns the IDs of the services held by the registry cache,
+// or registered during the last cycle when the cache can't be listed
+func (b *Breaker) managed() map[string]bool {
+	ids := make(map[string]bool)
+
+	if d, ok := b.Registry.(CacheDumper); ok {
+		for _, e := range d.CacheDump() {
+			ids[e.Service.ID] = true
+		}
+		return ids
+	}
+
+	for id := range b.lastSeen {
+		ids[id] = true
+	}
+	for id := range b.seen {
+		ids[id] = true
+	}
+
+	return ids
+}
+
+// Release lets the held deregistrations go ahead in the next cycle
+func (b *Breaker) Release() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if len(b.held) > 0 {
+		log.Warnf("Deregistration breaker released by operator, %d services will be deregistered", len(b.held))
+	}
+	b.released = true
+}
+
+// Held returns the IDs of the services whose deregistration is held
+func (b *Breaker) Held() []string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	held := make([]string, len(b.held))
+	copy(held, b.held)
+
+	return held
+}
+
+// CycleError reports held deregistrations, then the errors of the
+// wrapped registry
+func (b *Breaker) CycleError() error {
+	if held := b.Held(); len(held) > 0 {
+		return fmt.Errorf("holding the deregistration of %d services", len(held))
+	}
+
+	if cr, ok := b.Registry.(CycleReporter); ok {
+		return cr.CycleError()
+	}
+
+	return nil
+}
+
+func (b *Breaker) CacheDump() []CacheEntry {
+	if d, ok := b.Registry.(CacheDumper); ok {
+		return d.CacheDump()
+	}
+
+	return nil
+}
+
+func (b *Breaker) SetDefaultGrace(g Grace) {
+	if gs, ok := b.Registry.(GraceSetter); ok {
+		gs.SetDefaultGrace(g)
+	}
+}
+
+// CacheHold holds the service in the registry, or marks it when the
+// registry can't hold services
+func (b *Breaker) CacheHold(id string) {
+	if h, ok := b.Registry.(Holder); ok {
+		h.CacheHold(id)
+	} else {
+		b.Registry.CacheMark(id)
+	}
+}
+
+func (b *Breaker) Expiring() []string {
+	if e, ok := b.Registry.(Expirer); ok {
+		return e.Expiring()
+	}
+
+	return nil
+}
+
+func (b *Breaker) CacheAdopt(s *Service) {
+	if a, ok := b.Registry.(Adopter); ok {
+		a.CacheAdopt(s)
+	}
+}
+
+func (b *Breaker) CacheLoadAgent(host, serviceIdPrefix string) error {
+	if l, ok := b.Registry.(AgentCacheLoader); ok {
+		return l.CacheLoadAgent(host, serviceIdPrefix)
+	}
+
+	return nil
+}
+
+func (b *Breaker) List(agents []string, serviceIdPrefix string) ([]*Service, error) {
+	if l, ok := b.Registry.(Lister); ok {
+		return l.List(agents, serviceIdPrefix)
+	}
+
+	return nil, fmt.Errorf("the registry can't list its services")
+}
+
+func (b *Breaker) Reconcile(agents []string, serviceIdPrefix string) {
+	if r, ok := b.Registry.(Reconciler); ok {
+		r.Reconcile(agents, serviceIdPrefix)
+	}
+}
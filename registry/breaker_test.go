@@ -0,0 +1,166 @@
+package registry
+
+import (
+	"fmt"
+	"testing"
+)
+
+// cacheRegistry keeps every service until it was missing from a cycle
+// marked by Deregister, like the backends do with a threshold of 1
+type cacheRegistry struct {
+	cache   map[string]int
+	removed []string
+}
+
+func newCacheRegistry() *cacheRegistry {
+	return &cacheRegistry{cache: make(map[string]int)}
+}
+
+func (r *cacheRegistry) CacheCreate() bool                   { return false }
+func (r *cacheRegistry) CacheDelete(id string)               { delete(r.cache, id) }
+func (r *cacheRegistry) CacheLoad(host, prefix string) error { return nil }
+func (r *cacheRegistry) CacheLookup(id string) *Service      { return nil }
+func (r *cacheRegistry) CacheMark(id string) {
+	if _, ok := r.cache[id]; ok {
+		r.cache[id] = 0
+	}
+}
+func (r *cacheRegistry) Register(s *Service) { r.cache[s.ID] = 0 }
+
+func (r *cacheRegistry) Deregister() {
+	for id, n := range r.cache {
+		if n < 1 {
+			r.cache[id]++
+		} else {
+			r.removed = append(r.removed, id)
+			delete(r.cache, id)
+		}
+	}
+}
+
+func (r *cacheRegistry) CacheDump() []CacheEntry {
+	var entries []CacheEntry
+	for id, n := range r.cache {
+		entries = append(entries, CacheEntry{Service: &Service{ID: id}, ValidityCounter: n})
+	}
+	return entries
+}
+
+func cycle(b *Breaker, n int) {
+	for i := 0; i < n; i++ {
+		b.Register(&Service{ID: fmt.Sprintf("s%d", i)})
+	}
+	b.Deregister()
+}
+
+func TestBreakerHolds(t *testing.T) {
+	r := newCacheRegistry()
+	b := NewBreaker(r, 50, 0, 2)
+
+	cycle(b, 10)
+
+	// A few services going away is normal
+	cycle(b, 8)
+	cycle(b, 8)
+	if len(r.removed) != 2 || len(b.Held()) != 0 {
+		t.Fatalf("removed => %v, held => %v", r.removed, b.Held())
+	}
+
+	// Most of them going away is held
+	cycle(b, 1)
+	cycle(b, 1)
+	if len(r.removed) != 2 || len(b.Held()) != 7 {
+		t.Fatalf("removed => %v, held => %v", r.removed, b.Held())
+	}
+	if b.CycleError() == nil {
+		t.Error("held deregistrations not reported")
+	}
+
+	// Until enough consecutive cycles agree
+	cycle(b, 1)
+	cycle(b, 1)
+	if len(r.removed) != 9 || len(b.Held()) != 0 {
+		t.Errorf("removed => %v, held => %v", r.removed, b.Held())
+	}
+	if b.CycleError() != nil {
+		t.Errorf("CycleError() => %v", b.CycleError())
+	}
+}
+
+func TestBreakerRelease(t *testing.T) {
+	r := newCacheRegistry()
+	b := NewBreaker(r, 0, 3, 0)
+
+	cycle(b, 10)
+	for i := 0; i < 5; i++ {
+		cycle(b, 0)
+	}
+	if len(r.removed) != 0 || len(b.Held()) != 10 {
+		t.Fatalf("removed => %v, held => %v", r.removed, b.Held())
+	}
+
+	b.Release()
+	cycle(b, 0)
+	cycle(b, 0)
+	if len(r.removed) != 10 {
+		t.Errorf("removed => %v after release", r.removed)
+	}
+
+	// The breaker closes again once the registry is back to normal
+	cycle(b, 10)
+	cycle(b, 0)
+	if len(b.Held()) != 10 {
+		t.Errorf("held => %v after closing", b.Held())
+	}
+}
+
+// graceRegistry removes the services once they ran out of grace
+type graceRegistry struct {
+	Cache
+	removed []string
+}
+
+func (r *graceRegistry) CacheLoad(host, prefix string) error { return nil }
+func (r *graceRegistry) Register(s *Service)                 { r.CacheAdd(s, "") }
+
+func (r *graceRegistry) Deregister() {
+	r.CacheSweep(func(cs *CachedService) bool {
+		r.removed = append(r.removed, cs.Service.ID)
+		return true
+	})
+}
+
+func TestBreakerSkipsGrace(t *testing.T) {
+	for _, confirm := range []int{0, 3} {
+		r := &graceRegistry{Cache: NewCache("test")}
+		r.CacheCreate()
+		r.SetDefaultGrace(Grace{Cycles: 3})
+		b := NewBreaker(r, 50, 0, confirm)
+
+		// Services in their grace period are not about to be removed
+		cycle(b, 10)
+		cycle(b, 1)
+		cycle(b, 1)
+		if len(b.Held()) != 0 || len(r.removed) != 0 {
+			t.Fatalf("confirm %d: removed => %v, held => %v within the grace", confirm, r.removed, b.Held())
+		}
+
+		// They trip the breaker once their grace is over, and stay
+		// held without starting a new grace
+		for i := 1; i <= 3; i++ {
+			cycle(b, 1)
+			if len(b.Held()) != 9 || len(r.removed) != 0 {
+				t.Fatalf("confirm %d, cycle %d: removed => %v, held => %v after the grace", confirm, i, r.removed, b.Held())
+			}
+		}
+
+		// Until the cycles confirm it or an operator releases them
+		if confirm == 0 {
+			b.Release()
+		}
+		cycle(b, 1)
+		if len(b.Held()) != 0 || len(r.removed) != 9 {
+			t.Errorf("confirm %d: removed => %v, held => %v once released", confirm, r.removed, b.Held())
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package registry
+
+import (
+	"sort"
+	"time"
+
+	"github.com/mantl/mesos-consul/metrics"
+)
+
+// Cache holds the services a registry backend registered, and tells
+// which ones missed enough syncs to be removed. The backends embed it
+// for the cache part of the Registry interface.
+type Cache struct {
+	name    string
+	entries map[string]*CachedService
+
+	// Grace of the services that carry none
+	grace Grace
+
+	// Services the next CacheSweep keeps whatever their grace
+	held map[string]bool
+}
+
+// CachedService is a service held in a Cache
+type CachedService struct {
+	Service *Service
+
+	// Fingerprint of the service as registered, empty when it was
+	// loaded from the backend and not registered since
+	Fingerprint string
+
+	// Syncs the service missed, plus one. 0 during a sync that saw
+	// it, until Deregister.
+	validityCounter int
+	lastSeen        time.Time
+}
+
+// InGrace returns true while the service is missing from Mesos but kept
+func (cs *CachedService) InGrace() bool {
+	return cs.validityCounter > 1
+}
+
+// NewCache returns an empty cache, created by the first CacheCreate.
+// The name labels the metrics.
+func NewCache(name string) Cache {
+	return Cache{name: name, grace: Grace{Cycles: 1}}
+}
+
+// SetDefaultGrace sets the grace of the services that carry none
+func (c *Cache) SetDefaultGrace(g Grace) {
+	c.grace = g
+}
+
+// graceOf returns the grace of the cached service
+func (c *Cache) graceOf(cs *CachedService) Grace {
+	if cs.Service.Grace == nil {
+		return c.grace
+	}
+
+	return *cs.Service.Grace
+}
+
+// CacheCreate creates the cache, and returns true the first time
+func (c *Cache) CacheCreate() bool {
+	if c.entries == nil {
+		c.entries = make(map[string]*CachedService)
+		return true
+	}
+
+	return false
+}
+
+// CacheAdd adds the service, seen during the current sync
+func (c *Cache) CacheAdd(s *Service, fingerprint string) {
+	c.entries[s.ID] = &CachedService{Service: s, Fingerprint: fingerprint}
+}
+
+// Cached returns the cache entry of the service ID, or nil
+func (c *Cache) Cached(id string) *CachedService {
+	return c.entries[id]
+}
+
+// CacheIDs returns the IDs of the cached services, sorted
+func (c *Cache) CacheIDs() []string {
+	ids := make([]string, 0, len(c.entries))
+	for id := range c.entries {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	return ids
+}
+
+// CacheLookup returns a copy of the cached service, or nil
+func (c *Cache) CacheLookup(id string) *Service {
+	if cs, ok := c.entries[id]; ok {
+		s := *cs.Service
+		return &s
+	}
+
+	return nil
+}
+
+// CacheDelete removes the service ID from the cache
+func (c *Cache) CacheDelete(id string) {
+	delete(c.entries, id)
+}
+
+// CacheMark marks the service ID as seen during the current sync
+func (c *Cache) CacheMark(id string) {
+	if cs, ok := c.entries[id]; ok {
+		cs.validityCounter = 0
+	}
+}
+
+// CacheHold keeps the service ID through the next CacheSweep. Its
+// grace is left as is, so that it expires again in the sweep after.
+func (c *Cache) CacheHold(id string) {
+	if c.held == nil {
+		c.held = make(map[string]bool)
+	}
+	c.held[id] = true
+}
+
+// CacheDump lists the cache, ordered by service ID
+func (c *Cache) CacheDump() []CacheEntry {
+	ids := c.CacheIDs()
+
+	entries := make([]CacheEntry, 0, len(ids))
+	for _, id := range ids {
+		cs := c.entries[id]
+		entries = append(entries, NewCacheEntry(c.CacheLookup(id), c.graceOf(cs), cs.validityCounter, cs.lastSeen))
+	}
+
+	return entries
+}
+
+// Expiring returns the IDs of the services the next CacheSweep hands
+// to remove, sorted
+func (c *Cache) Expiring() []string {
+	now := time.Now()
+
+	var ids []string
+	for id, cs := range c.entries {
+		if !c.graceOf(cs).Keep(cs.validityCounter, cs.lastSeen, now) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	return ids
+}
+
+// CacheSweep ends a sync. The services that missed it and ran out of
+// grace are handed to remove, and dropped from the cache when it
+// returns true. The others start or go on counting missed syncs.
+func (c *Cache) CacheSweep(remove func(cs *CachedService) bool) {
+	now := time.Now()
+
+	for id, cs := range c.entries {
+		if c.graceOf(cs).Keep(cs.validityCounter, cs.lastSeen, now) {
+			if cs.validityCounter == 0 {
+				cs.lastSeen = now
+			}
+			cs.validityCounter++
+			continue
+		}
+
+		if c.held[id] {
+			continue
+		}
+
+		if remove(cs) {
+			delete(c.entries, id)
+		}
+	}
+	c.held = nil
+
+	inGrace := 0
+	for _, cs := range c.entries {
+		if cs.InGrace() {
+			inGrace++
+		}
+	}
+
+	metrics.CacheSize.WithLabelValues(c.name).Set(float64(len(c.entries)))
+	metrics.ServicesInGrace.WithLabelValues(c.name).Set(float64(inGrace))
+}
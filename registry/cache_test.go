@@ -0,0 +1,69 @@
+package registry
+
+import (
+	"testing"
+)
+
+func TestCacheSweep(t *testing.T) {
+	c := NewCache("test")
+	if !c.CacheCreate() || c.CacheCreate() {
+		t.Fatal("CacheCreate() should return true on the first call only")
+	}
+
+	c.CacheAdd(&Service{ID: "gone"}, "")
+	c.CacheAdd(&Service{ID: "kept", Grace: &Grace{Cycles: 3}}, "")
+	c.CacheAdd(&Service{ID: "failing"}, "")
+
+	var removed []string
+	remove := func(cs *CachedService) bool {
+		removed = append(removed, cs.Service.ID)
+		return cs.Service.ID != "failing"
+	}
+
+	// Every service was seen by the sync
+	c.CacheSweep(remove)
+	if len(removed) != 0 {
+		t.Fatalf("removed during a sync that saw them: %v", removed)
+	}
+
+	for cycle := 1; cycle <= 3; cycle++ {
+		c.CacheSweep(remove)
+	}
+
+	if ids := c.CacheIDs(); len(ids) != 1 || ids[0] != "failing" {
+		t.Errorf("cache after 3 missed cycles => %v", ids)
+	}
+
+	c.CacheAdd(&Service{ID: "kept", Grace: &Grace{Cycles: 3}}, "")
+	c.CacheSweep(remove)
+	c.CacheSweep(remove)
+	if !c.Cached("kept").InGrace() {
+		t.Error("a missing service within its grace should be in grace")
+	}
+
+	c.CacheMark("kept")
+	if c.Cached("kept").InGrace() {
+		t.Error("CacheMark() should reset the missed syncs")
+	}
+
+	if s := c.CacheLookup("failing"); s == nil || s == c.Cached("failing").Service {
+		t.Error("CacheLookup() should return a copy")
+	}
+}
+
+func TestCacheDefaultGrace(t *testing.T) {
+	c := NewCache("test")
+	c.CacheCreate()
+	c.SetDefaultGrace(Grace{Cycles: 3})
+
+	c.CacheAdd(&Service{ID: "loaded"}, "")
+	c.CacheAdd(&Service{ID: "own", Grace: &Grace{Cycles: 1}}, "")
+
+	remove := func(*CachedService) bool { return true }
+	c.CacheSweep(remove)
+	c.CacheSweep(remove)
+
+	if ids := c.CacheIDs(); len(ids) != 1 || ids[0] != "loaded" {
+		t.Errorf("services without a grace should follow the default grace: %v", ids)
+	}
+}
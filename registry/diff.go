@@ -0,0 +1,128 @@
+package registry
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Lister is implemented by registries that can list the services
+// their backend actually holds. Services are set Agent to the agent
+// they were found on.
+type Lister interface {
+	List(agents []string, serviceIdPrefix string) ([]*Service, error)
+}
+
+// Diff actions
+const (
+	DiffAdd    = "add"
+	DiffChange = "change"
+	DiffRemove = "remove"
+)
+
+// FieldChange is a field of a service that differs
+type FieldChange struct {
+	Field string `json:"field"`
+	Old   string `json:"old"`
+	New   string `json:"new"`
+}
+
+// ServiceDiff is a change a sync would make to a service
+type ServiceDiff struct {
+	Action  string        `json:"action"`
+	Agent   string        `json:"agent"`
+	ID      string        `json:"id"`
+	Service *Service      `json:"service"`
+	Fields  []FieldChange `json:"fields,omitempty"`
+}
+
+// Diff compares the desired services with the actual ones and returns
+// the changes, ordered by agent, action and ID. Only the fields visible
+// in the backend are compared: name, address, port, tags and meta.
+func Diff(desired, actual []*Service) []ServiceDiff {
+	have := make(map[string]*Service, len(actual))
+	for _, s := range actual {
+		have[s.ID] = s
+	}
+
+	var diffs []ServiceDiff
+	want := make(map[string]bool, len(desired))
+	for _, s := range desired {
+		if want[s.ID] {
+			continue
+		}
+		want[s.ID] = true
+
+		a, ok := have[s.ID]
+		if !ok {
+			diffs = append(diffs, ServiceDiff{Action: DiffAdd, Agent: s.Agent, ID: s.ID, Service: s})
+			continue
+		}
+
+		if fields := compareFields(a, s); len(fields) > 0 {
+			diffs = append(diffs, ServiceDiff{Action: DiffChange, Agent: s.Agent, ID: s.ID, Service: s, Fields: fields})
+		}
+	}
+
+	for _, s := range actual {
+		if !want[s.ID] {
+			diffs = append(diffs, ServiceDiff{Action: DiffRemove, Agent: s.Agent, ID: s.ID, Service: s})
+		}
+	}
+
+	sort.Sort(byAgent(diffs))
+
+	return diffs
+}
+
+func compareFields(a, b *Service) []FieldChange {
+	var fields []FieldChange
+
+	add := func(field, old, new string) {
+		if old != new {
+			fields = append(fields, FieldChange{Field: field, Old: old, New: new})
+		}
+	}
+
+	add("name", a.Name, b.Name)
+	add("address", a.Address, b.Address)
+	add("port", fmt.Sprintf("%d", a.Port), fmt.Sprintf("%d", b.Port))
+	add("tags", sortedTags(a.Tags), sortedTags(b.Tags))
+	add("meta", sortedMeta(a.Meta), sortedMeta(b.Meta))
+
+	return fields
+}
+
+func sortedTags(tags []string) string {
+	t := make([]string, len(tags))
+	copy(t, tags)
+	sort.Strings(t)
+
+	return strings.Join(t, ",")
+}
+
+func sortedMeta(meta map[string]string) string {
+	kv := make([]string, 0, len(meta))
+	for k, v := range meta {
+		kv = append(kv, k+"="+v)
+	}
+	sort.Strings(kv)
+
+	return strings.Join(kv, ",")
+}
+
+var actionOrder = map[string]int{DiffAdd: 0, DiffChange: 1, DiffRemove: 2}
+
+type byAgent []ServiceDiff
+
+func (d byAgent) Len() int      { return len(d) }
+func (d byAgent) Swap(i, j int) { d[i], d[j] = d[j], d[i] }
+func (d byAgent) Less(i, j int) bool {
+	if d[i].Agent != d[j].Agent {
+		return d[i].Agent < d[j].Agent
+	}
+	if d[i].Action != d[j].Action {
+		return actionOrder[d[i].Action] < actionOrder[d[j].Action]
+	}
+	return d[i].ID < d[j].ID
+}
@@ -0,0 +1,55 @@
+package registry
+
+import (
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	desired := []*Service{
+		{ID: "p:web", Name: "web", Port: 80, Address: "10.0.0.1", Agent: "10.0.0.1", Tags: []string{"b", "a"}},
+		{ID: "p:api", Name: "api", Port: 81, Address: "10.0.0.1", Agent: "10.0.0.1"},
+		{ID: "p:db", Name: "db", Port: 5432, Address: "10.0.0.2", Agent: "10.0.0.2"},
+	}
+	actual := []*Service{
+		{ID: "p:web", Name: "web", Port: 80, Address: "10.0.0.1", Agent: "10.0.0.1", Tags: []string{"a", "b"}},
+		{ID: "p:db", Name: "db", Port: 5433, Address: "10.0.0.2", Agent: "10.0.0.2", Tags: []string{"x"}},
+		{ID: "p:old", Name: "old", Port: 82, Address: "10.0.0.1", Agent: "10.0.0.1"},
+	}
+
+	diffs := Diff(desired, actual)
+
+	want := []struct {
+		action string
+		id     string
+		fields int
+	}{
+		{DiffAdd, "p:api", 0},
+		{DiffRemove, "p:old", 0},
+		{DiffChange, "p:db", 2},
+	}
+
+	if len(diffs) != len(want) {
+		t.Fatalf("got %d changes, want %d: %+v", len(diffs), len(want), diffs)
+	}
+	for i, w := range want {
+		d := diffs[i]
+		if d.Action != w.action || d.ID != w.id || len(d.Fields) != w.fields {
+			t.Errorf("change %d => %s %s %+v, want %s %s with %d fields", i, d.Action, d.ID, d.Fields, w.action, w.id, w.fields)
+		}
+	}
+
+	if f := diffs[2].Fields[0]; f.Field != "port" || f.Old != "5433" || f.New != "5432" {
+		t.Errorf("port change => %+v", f)
+	}
+
+	metaDesired := []*Service{{ID: "p:web", Agent: "a", Meta: map[string]string{"version": "2", "team": "x"}}}
+	metaActual := []*Service{{ID: "p:web", Agent: "a", Meta: map[string]string{"team": "x", "version": "1"}}}
+	d := Diff(metaDesired, metaActual)
+	if len(d) != 1 || len(d[0].Fields) != 1 || d[0].Fields[0].New != "team=x,version=2" {
+		t.Errorf("meta change => %+v", d)
+	}
+
+	if len(Diff(desired, desired)) != 0 {
+		t.Error("identical sets differ")
+	}
+}
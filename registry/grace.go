@@ -0,0 +1,24 @@
+package registry
+
+import (
+	"time"
+)
+
+// Grace is how long a service is kept after its task disappeared from
+// Mesos. It is removed once it missed more than Cycles syncs and has
+// been missing for at least Duration.
+type Grace struct {
+	Cycles   int
+	Duration time.Duration
+}
+
+// Keep returns true unless a service missed the given number of syncs,
+// counting the current one, and was last seen long enough ago. A
+// service registered during the current sync missed none.
+func (g Grace) Keep(missed int, lastSeen, now time.Time) bool {
+	if missed < 1 || missed < g.Cycles {
+		return true
+	}
+
+	return now.Sub(lastSeen) < g.Duration
+}
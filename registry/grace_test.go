@@ -0,0 +1,32 @@
+package registry
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGraceKeep(t *testing.T) {
+	now := time.Now()
+
+	for _, tt := range []struct {
+		name   string
+		grace  Grace
+		missed int
+		since  time.Duration // since last seen
+		keep   bool
+	}{
+		{"registered", Grace{}, 0, 0, true},
+		{"no grace", Grace{}, 1, time.Minute, false},
+		{"first miss", Grace{Cycles: 1}, 1, time.Minute, false},
+		{"within cycles", Grace{Cycles: 3}, 2, time.Hour, true},
+		{"cycles over", Grace{Cycles: 3}, 3, time.Hour, false},
+		{"within duration", Grace{Duration: 90 * time.Second}, 5, time.Minute, true},
+		{"duration over", Grace{Duration: 90 * time.Second}, 5, 2 * time.Minute, false},
+		{"cycles left, duration over", Grace{Cycles: 10, Duration: time.Second}, 5, time.Minute, true},
+		{"both over", Grace{Cycles: 2, Duration: time.Second}, 2, time.Minute, false},
+	} {
+		if keep := tt.grace.Keep(tt.missed, now.Add(-tt.since), now); keep != tt.keep {
+			t.Errorf("%s: Keep() => %t, want %t", tt.name, keep, tt.keep)
+		}
+	}
+}
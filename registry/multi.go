@@ -0,0 +1,251 @@
+package registry
+
+import (
+	"fmt"
+	"sort"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Multi forwards every call to several registries, so that the
+// services found in a single poll of Mesos are written to all of them.
+// Each registry keeps its own cache and handles its own errors. A
+// registry that panics is logged and skipped for that call, so it
+// can't stop the others.
+type Multi struct {
+	registries []namedRegistry
+}
+
+type namedRegistry struct {
+	name string
+	Registry
+}
+
+func NewMulti() *Multi {
+	return &Multi{}
+}
+
+// Add appends a registry. The name is only used in logs.
+func (m *Multi) Add(name string, r Registry) {
+	m.registries = append(m.registries, namedRegistry{name: name, Registry: r})
+}
+
+// Len returns the number of registries
+func (m *Multi) Len() int {
+	return len(m.registries)
+}
+
+// each calls f for every registry, recovering from panics
+func (m *Multi) each(call string, f func(Registry)) {
+	for _, r := range m.registries {
+		func() {
+			defer func() {
+				if rec := recover(); rec != nil {
+					log.WithField("registry", r.name).Errorf("%s failed: %v", call, rec)
+				}
+			}()
+
+			f(r.Registry)
+		}()
+	}
+}
+
+func (m *Multi) CacheCreate() bool {
+	created := false
+	m.each("CacheCreate", func(r Registry) {
+		if r.CacheCreate() {
+			created = true
+		}
+	})
+
+	return created
+}
+
+func (m *Multi) CacheDelete(id string) {
+	m.each("CacheDelete", func(r Registry) { r.CacheDelete(id) })
+}
+
+// CacheLoad loads every cache and returns the first error
+func (m *Multi) CacheLoad(host, serviceIdPrefix string) error {
+	var rerr error
+	m.each("CacheLoad", func(r Registry) {
+		if err := r.CacheLoad(host, serviceIdPrefix); err != nil && rerr == nil {
+			rerr = err
+		}
+	})
+
+	return rerr
+}
+
+// CacheLoadAgent loads the caches of the registries that implement
+// AgentCacheLoader and returns the first error
+func (m *Multi) CacheLoadAgent(host, serviceIdPrefix string) error {
+	var rerr error
+	m.each("CacheLoadAgent", func(r Registry) {
+		l, ok := r.(AgentCacheLoader)
+		if !ok {
+			return
+		}
+		if err := l.CacheLoadAgent(host, serviceIdPrefix); err != nil && rerr == nil {
+			rerr = err
+		}
+	})
+
+	return rerr
+}
+
+// CacheLookup returns the service from the first registry that has it
+func (m *Multi) CacheLookup(id string) *Service {
+	var s *Service
+	m.each("CacheLookup", func(r Registry) {
+		if s == nil {
+			s = r.CacheLookup(id)
+		}
+	})
+
+	return s
+}
+
+func (m *Multi) CacheMark(id string) {
+	m.each("CacheMark", func(r Registry) { r.CacheMark(id) })
+}
+
+func (m *Multi) Register(s *Service) {
+	m.each("Register", func(r Registry) { r.Register(copyService(s)) })
+}
+
+// copyService gives every registry its own copy of s, so that none
+// can see changes made by another
+func copyService(s *Service) *Service {
+	c := *s
+	c.Tags = append([]string(nil), s.Tags...)
+	if s.Check != nil {
+		check := *s.Check
+		c.Check = &check
+	}
+	c.Checks = nil
+	for _, sc := range s.Checks {
+		check := *sc
+		c.Checks = append(c.Checks, &check)
+	}
+	if s.Meta != nil {
+		c.Meta = make(map[string]string, len(s.Meta))
+		for k, v := range s.Meta {
+			c.Meta[k] = v
+		}
+	}
+
+	return &c
+}
+
+func (m *Multi) Deregister() {
+	m.each("Deregister", func(r Registry) { r.Deregister() })
+}
+
+// CycleError returns the first error of the registries that
+// implement CycleReporter
+func (m *Multi) CycleError() error {
+	var rerr error
+	for _, r := range m.registries {
+		cr, ok := r.Registry.(CycleReporter)
+		if !ok {
+			continue
+		}
+		if err := cr.CycleError(); err != nil && rerr == nil {
+			rerr = fmt.Errorf("%s: %s", r.name, err)
+		}
+	}
+
+	return rerr
+}
+
+// CacheDump lists the caches of the registries that implement
+// CacheDumper, naming the registry of each entry
+func (m *Multi) CacheDump() []CacheEntry {
+	var entries []CacheEntry
+	for _, r := range m.registries {
+		d, ok := r.Registry.(CacheDumper)
+		if !ok {
+			continue
+		}
+		for _, e := range d.CacheDump() {
+			e.Registry = r.name
+			entries = append(entries, e)
+		}
+	}
+
+	return entries
+}
+
+// SetDefaultGrace forwards to the registries that implement GraceSetter
+func (m *Multi) SetDefaultGrace(g Grace) {
+	m.each("SetDefaultGrace", func(r Registry) {
+		if gs, ok := r.(GraceSetter); ok {
+			gs.SetDefaultGrace(g)
+		}
+	})
+}
+
+// CacheAdopt forwards to the registries that implement Adopter
+func (m *Multi) CacheAdopt(s *Service) {
+	m.each("CacheAdopt", func(r Registry) {
+		if a, ok := r.(Adopter); ok {
+			a.CacheAdopt(copyService(s))
+		}
+	})
+}
+
+// CacheHold forwards to the registries that implement Holder, and
+// marks the service in the others
+func (m *Multi) CacheHold(id string) {
+	m.each("CacheHold", func(r Registry) {
+		if h, ok := r.(Holder); ok {
+			h.CacheHold(id)
+		} else {
+			r.CacheMark(id)
+		}
+	})
+}
+
+// Expiring returns the services any registry that implements Expirer
+// is about to remove
+func (m *Multi) Expiring() []string {
+	seen := make(map[string]bool)
+	var ids []string
+	m.each("Expiring", func(r Registry) {
+		e, ok := r.(Expirer)
+		if !ok {
+			return
+		}
+		for _, id := range e.Expiring() {
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+	})
+	sort.Strings(ids)
+
+	return ids
+}
+
+// List returns the services of the first registry that implements
+// Lister
+func (m *Multi) List(agents []string, serviceIdPrefix string) ([]*Service, error) {
+	for _, r := range m.registries {
+		if l, ok := r.Registry.(Lister); ok {
+			return l.List(agents, serviceIdPrefix)
+		}
+	}
+
+	return nil, fmt.Errorf("no registry can list its services")
+}
+
+// Reconcile forwards to the registries that implement Reconciler
+func (m *Multi) Reconcile(agents []string, serviceIdPrefix string) {
+	m.each("Reconcile", func(r Registry) {
+		if rc, ok := r.(Reconciler); ok {
+			rc.Reconcile(agents, serviceIdPrefix)
+		}
+	})
+}
@@ -0,0 +1,44 @@
+package registry
+
+import "testing"
+
+type recordingRegistry struct {
+	registered []string
+	panics     bool
+}
+
+func (r *recordingRegistry) CacheCreate() bool              { return true }
+func (r *recordingRegistry) CacheDelete(string)             {}
+func (r *recordingRegistry) CacheLoad(string, string) error { return nil }
+func (r *recordingRegistry) CacheLookup(string) *Service    { return nil }
+func (r *recordingRegistry) CacheMark(string)               {}
+func (r *recordingRegistry) Deregister()                    {}
+func (r *recordingRegistry) Register(s *Service) {
+	if r.panics {
+		panic("broken backend")
+	}
+	s.Tags = append(s.Tags[:0], "modified")
+	s.Meta["key"] = "modified"
+	r.registered = append(r.registered, s.ID)
+}
+
+func TestMultiIsolation(t *testing.T) {
+	broken := &recordingRegistry{panics: true}
+	first := &recordingRegistry{}
+	second := &recordingRegistry{}
+
+	m := NewMulti()
+	m.Add("broken", broken)
+	m.Add("first", first)
+	m.Add("second", second)
+
+	s := &Service{ID: "one", Tags: []string{"a"}, Meta: map[string]string{"key": "a"}}
+	m.Register(s)
+
+	if len(first.registered) != 1 || len(second.registered) != 1 {
+		t.Errorf("a panicking registry stopped the others: %v, %v", first.registered, second.registered)
+	}
+	if s.Tags[0] != "a" || s.Meta["key"] != "a" {
+		t.Error("a registry modified the caller's service")
+	}
+}
@@ -0,0 +1,19 @@
+package registry
+
+// Recorder is a registry that only records the services it is given.
+// It is used to compute the services a sync would register without
+// touching any backend.
+type Recorder struct {
+	Services []*Service
+}
+
+func (r *Recorder) CacheCreate() bool                   { return false }
+func (r *Recorder) CacheDelete(id string)               {}
+func (r *Recorder) CacheLoad(host, prefix string) error { return nil }
+func (r *Recorder) CacheLookup(id string) *Service      { return nil }
+func (r *Recorder) CacheMark(id string)                 {}
+func (r *Recorder) Deregister()                         {}
+
+func (r *Recorder) Register(s *Service) {
+	r.Services = append(r.Services, s)
+}
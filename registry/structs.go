@@ -1,11 +1,42 @@
 package registry
 
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"time"
+)
+
 type Check struct {
 	Script   string
 	TTL      string
 	TCP      string
 	HTTP     string
 	Interval string
+	Timeout  string
+	Name     string
+	Notes    string
+
+	Method        string
+	Header        map[string][]string
+	TLSSkipVerify bool
+	GRPC          string
+	GRPCUseTLS    bool
+
+	// Run the script check inside this Docker container
+	DockerContainerID string
+
+	// Have Consul remove the service when the check stays critical
+	// this long, for example while mesos-consul is down
+	DeregisterCriticalServiceAfter string
+
+	// ID of the check, and the state of a TTL check pushed on every
+	// sync. Status is one of passing, warning or critical. They are
+	// not part of the fingerprint.
+	ID     string
+	Status string
+	Output string
 }
 
 type Service struct {
@@ -15,7 +46,18 @@ type Service struct {
 	Address string
 	Tags    []string
 	Check   *Check
+	Checks  []*Check
 	Agent   string
+	Meta    map[string]string
+
+	// Mesos task the service was generated from. Empty for
+	// the master and agent services.
+	TaskID    string
+	Framework string
+
+	// How long the service is kept once its task is gone, nil for
+	// the default grace of the registry. Not part of the fingerprint.
+	Grace *Grace `json:"-"`
 }
 
 type Registry interface {
@@ -29,6 +71,98 @@ type Registry interface {
 	Deregister()
 }
 
+// Reconciler is implemented by registries that can compare the services
+// they registered with what each agent actually holds, and repair the
+// differences.
+type Reconciler interface {
+	Reconcile(agents []string, serviceIdPrefix string)
+}
+
+// CycleReporter is implemented by registries that can tell whether
+// the last cycle reached the backend. CycleError returns nil when it
+// did, or when the cycle made no calls.
+type CycleReporter interface {
+	CycleError() error
+}
+
+// AgentCacheLoader is implemented by registries that can load their
+// cache from the services of a single agent, instead of every service
+// in the cluster.
+type AgentCacheLoader interface {
+	CacheLoadAgent(host, serviceIdPrefix string) error
+}
+
+// GraceSetter is implemented by registries that hold services without
+// a grace of their own, such as the services loaded from the backend.
+// SetDefaultGrace sets the grace of those services.
+type GraceSetter interface {
+	SetDefaultGrace(Grace)
+}
+
+// Expirer is implemented by registries that can tell which services
+// the next Deregister removes: the services that were not registered
+// nor marked since the last Deregister, and ran out of grace.
+type Expirer interface {
+	Expiring() []string
+}
+
+// Holder is implemented by registries that can keep a service through
+// the next Deregister without touching its grace, so that it is still
+// about to be removed in the cycle after.
+type Holder interface {
+	CacheHold(id string)
+}
+
+// Adopter is implemented by registries that can take a registration
+// loaded from the backend as their own, when it is the service another
+// instance with the same configuration registered. CacheAdopt gives the
+// cached registration the fingerprint of s when it matches s, the
+// InstanceMeta and the checks aside.
+type Adopter interface {
+	CacheAdopt(s *Service)
+}
+
+// InstanceMeta is the metadata key naming the mesos-consul instance
+// that registered a service
+const InstanceMeta = "mesos_consul_instance"
+
+// CacheEntry is a service held in a registry cache
+type CacheEntry struct {
+	Registry        string   `json:"registry,omitempty"`
+	Service         *Service `json:"service"`
+	ValidityCounter int      `json:"validity_counter"`
+
+	// Last time a sync saw the service, set while it is missing from
+	// Mesos but kept for its grace period
+	MissingSince  *time.Time `json:"missing_since,omitempty"`
+	GraceCycles   int        `json:"grace_cycles"`
+	GraceDuration string     `json:"grace_duration,omitempty"`
+}
+
+// NewCacheEntry returns the cache entry of a service. The validity
+// counter of the backends is 1 after a sync that saw the service, and
+// grows by one with every sync that missed it.
+func NewCacheEntry(s *Service, grace Grace, validityCounter int, lastSeen time.Time) CacheEntry {
+	e := CacheEntry{
+		Service:         s,
+		ValidityCounter: validityCounter,
+		GraceCycles:     grace.Cycles,
+	}
+	if grace.Duration > 0 {
+		e.GraceDuration = grace.Duration.String()
+	}
+	if validityCounter > 1 {
+		e.MissingSince = &lastSeen
+	}
+
+	return e
+}
+
+// CacheDumper is implemented by registries that can list their cache
+type CacheDumper interface {
+	CacheDump() []CacheEntry
+}
+
 func DefaultCheck() *Check {
 	return &Check{
 		TTL:      "",
@@ -37,3 +171,54 @@ func DefaultCheck() *Check {
 		Interval: "",
 	}
 }
+
+// HasType()
+//   Return true if the check says what to check
+//
+func (c *Check) HasType() bool {
+	return c.HTTP != "" || c.Script != "" || c.TCP != "" || c.TTL != "" || c.GRPC != ""
+}
+
+// Fingerprint returns a canonical digest of every field of the service,
+// including its checks but not the status of TTL checks. Two services
+// with the same fields get the same fingerprint regardless of the order
+// of their tags.
+func (s *Service) Fingerprint() string {
+	c := *s
+
+	c.Tags = make([]string, len(s.Tags))
+	copy(c.Tags, s.Tags)
+	sort.Strings(c.Tags)
+
+	if c.Check == nil {
+		c.Check = DefaultCheck()
+	}
+	c.Check = withoutStatus(c.Check)
+
+	if len(s.Checks) > 0 {
+		c.Checks = make([]*Check, len(s.Checks))
+		for i, check := range s.Checks {
+			c.Checks[i] = withoutStatus(check)
+		}
+	}
+
+	b, err := json.Marshal(c)
+	if err != nil {
+		// Can't happen with the types above
+		panic(err)
+	}
+
+	sum := sha1.Sum(b)
+
+	return hex.EncodeToString(sum[:])
+}
+
+// withoutStatus returns a copy of the check without its TTL status,
+// which changes without the registration changing
+func withoutStatus(check *Check) *Check {
+	c := *check
+	c.Status = ""
+	c.Output = ""
+
+	return &c
+}
@@ -0,0 +1,57 @@
+package registry
+
+import "testing"
+
+func TestServiceFingerprint(t *testing.T) {
+	base := func() *Service {
+		return &Service{
+			ID:      "mesos-consul:10.0.0.1:web:10.0.0.1:31000",
+			Name:    "web",
+			Port:    31000,
+			Address: "10.0.0.1",
+			Agent:   "10.0.0.1",
+			Tags:    []string{"a", "b"},
+			Check: &Check{
+				HTTP:     "http://10.0.0.1:31000/health",
+				Interval: "10s",
+			},
+		}
+	}
+
+	fp := base().Fingerprint()
+
+	for _, tt := range []struct {
+		name   string
+		modify func(*Service)
+		same   bool
+	}{
+		{"unchanged", func(s *Service) {}, true},
+		{"tag order", func(s *Service) { s.Tags = []string{"b", "a"} }, true},
+		{"tag added", func(s *Service) { s.Tags = append(s.Tags, "c") }, false},
+		{"address", func(s *Service) { s.Address = "10.0.0.2" }, false},
+		{"port", func(s *Service) { s.Port = 31001 }, false},
+		{"check http", func(s *Service) { s.Check.HTTP = "http://10.0.0.1:31000/ping" }, false},
+		{"check interval", func(s *Service) { s.Check.Interval = "30s" }, false},
+		{"check removed", func(s *Service) { s.Check = nil }, false},
+		{"check status", func(s *Service) { s.Check.Status = "critical"; s.Check.Output = "down" }, true},
+		{"ttl check added", func(s *Service) { s.Checks = []*Check{{ID: "h", TTL: "3m"}} }, false},
+	} {
+		s := base()
+		tt.modify(s)
+
+		if got := s.Fingerprint() == fp; got != tt.same {
+			t.Errorf("%s: fingerprint equal => %t, want %t", tt.name, got, tt.same)
+		}
+	}
+
+	s := base()
+	s.Tags = []string{"b", "a"}
+	s.Fingerprint()
+	if s.Tags[0] != "b" {
+		t.Error("Fingerprint() must not reorder the service tags")
+	}
+
+	if (&Service{}).Fingerprint() != (&Service{Check: DefaultCheck()}).Fingerprint() {
+		t.Error("a nil check should fingerprint like an empty check")
+	}
+}
@@ -0,0 +1,208 @@
+package state
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Event types sent by the v1 Operator API SUBSCRIBE call.
+const (
+	EventSubscribed       = "SUBSCRIBED"
+	EventHeartbeat        = "HEARTBEAT"
+	EventTaskAdded        = "TASK_ADDED"
+	EventTaskUpdated      = "TASK_UPDATED"
+	EventAgentAdded       = "AGENT_ADDED"
+	EventAgentRemoved     = "AGENT_REMOVED"
+	EventFrameworkAdded   = "FRAMEWORK_ADDED"
+	EventFrameworkUpdated = "FRAMEWORK_UPDATED"
+	EventFrameworkRemoved = "FRAMEWORK_REMOVED"
+)
+
+// Event holds a single event as defined by the Mesos v1 Operator API.
+// Only the events used by mesos-consul are decoded.
+type Event struct {
+	Type string `json:"type"`
+
+	Subscribed *struct {
+		GetState                 GetState `json:"get_state"`
+		HeartbeatIntervalSeconds float64  `json:"heartbeat_interval_seconds"`
+	} `json:"subscribed,omitempty"`
+
+	TaskAdded *struct {
+		Task V1Task `json:"task"`
+	} `json:"task_added,omitempty"`
+
+	TaskUpdated *struct {
+		FrameworkID V1ID     `json:"framework_id"`
+		Status      V1Status `json:"status"`
+		State       string   `json:"state"`
+	} `json:"task_updated,omitempty"`
+
+	AgentAdded *struct {
+		Agent V1Agent `json:"agent"`
+	} `json:"agent_added,omitempty"`
+
+	AgentRemoved *struct {
+		AgentID V1ID `json:"agent_id"`
+	} `json:"agent_removed,omitempty"`
+
+	FrameworkAdded *struct {
+		Framework V1Framework `json:"framework"`
+	} `json:"framework_added,omitempty"`
+
+	FrameworkUpdated *struct {
+		Framework V1Framework `json:"framework"`
+	} `json:"framework_updated,omitempty"`
+
+	FrameworkRemoved *struct {
+		FrameworkInfo V1FrameworkInfo `json:"framework_info"`
+	} `json:"framework_removed,omitempty"`
+}
+
+// GetState holds the cluster snapshot sent in the SUBSCRIBED event.
+type GetState struct {
+	GetTasks struct {
+		Tasks []V1Task `json:"tasks"`
+	} `json:"get_tasks"`
+	GetFrameworks struct {
+		Frameworks []V1Framework `json:"frameworks"`
+	} `json:"get_frameworks"`
+	GetAgents struct {
+		Agents []V1Agent `json:"agents"`
+	} `json:"get_agents"`
+}
+
+// V1ID holds the {"value": ...} wrapper used for IDs by the v1 API.
+type V1ID struct {
+	Value string `json:"value"`
+}
+
+// V1Labels holds the {"labels": [...]} wrapper used for labels by the v1 API.
+type V1Labels struct {
+	Labels []Label `json:"labels"`
+}
+
+// V1Resource holds a single resource as defined by the v1 API.
+type V1Resource struct {
+	Name   string `json:"name"`
+	Type   string `json:"type"`
+	Ranges struct {
+		Range []struct {
+			Begin uint64 `json:"begin"`
+			End   uint64 `json:"end"`
+		} `json:"range"`
+	} `json:"ranges"`
+}
+
+// V1Status holds a task status as defined by the v1 API.
+type V1Status struct {
+	TaskID          V1ID            `json:"task_id"`
+	State           string          `json:"state"`
+	Message         string          `json:"message,omitempty"`
+	Timestamp       float64         `json:"timestamp"`
+	Healthy         *bool           `json:"healthy,omitempty"`
+	Labels          V1Labels        `json:"labels"`
+	ContainerStatus ContainerStatus `json:"container_status,omitempty"`
+}
+
+// V1Task holds a task as defined by the v1 API.
+type V1Task struct {
+	Name        string        `json:"name"`
+	TaskID      V1ID          `json:"task_id"`
+	FrameworkID V1ID          `json:"framework_id"`
+	AgentID     V1ID          `json:"agent_id"`
+	State       string        `json:"state"`
+	Resources   []V1Resource  `json:"resources"`
+	Statuses    []V1Status    `json:"statuses"`
+	Labels      V1Labels      `json:"labels"`
+	Discovery   DiscoveryInfo `json:"discovery"`
+}
+
+// V1AgentInfo holds the agent description as defined by the v1 API.
+type V1AgentInfo struct {
+	ID       V1ID   `json:"id"`
+	Hostname string `json:"hostname"`
+	Port     int    `json:"port"`
+}
+
+// V1Agent holds an agent as defined by the v1 API.
+type V1Agent struct {
+	AgentInfo V1AgentInfo `json:"agent_info"`
+	PID       string      `json:"pid"`
+	Active    bool        `json:"active"`
+}
+
+// V1FrameworkInfo holds the framework description as defined by the v1 API.
+type V1FrameworkInfo struct {
+	ID       V1ID   `json:"id"`
+	Name     string `json:"name"`
+	Hostname string `json:"hostname"`
+}
+
+// V1Framework holds a framework as defined by the v1 API.
+type V1Framework struct {
+	FrameworkInfo V1FrameworkInfo `json:"framework_info"`
+	Active        bool            `json:"active"`
+}
+
+// ToStatus converts a v1 task status to its /state.json representation.
+func (s V1Status) ToStatus() Status {
+	return Status{
+		Timestamp:       s.Timestamp,
+		State:           s.State,
+		Labels:          s.Labels.Labels,
+		ContainerStatus: s.ContainerStatus,
+		Healthy:         s.Healthy,
+		Message:         s.Message,
+	}
+}
+
+// ToTask converts a v1 task to its /state.json representation.
+func (t V1Task) ToTask() Task {
+	task := Task{
+		FrameworkID:   t.FrameworkID.Value,
+		ID:            t.TaskID.Value,
+		Name:          t.Name,
+		SlaveID:       t.AgentID.Value,
+		State:         t.State,
+		Labels:        t.Labels.Labels,
+		DiscoveryInfo: t.Discovery,
+	}
+
+	for _, s := range t.Statuses {
+		task.Statuses = append(task.Statuses, s.ToStatus())
+	}
+
+	for _, r := range t.Resources {
+		if r.Name != "ports" || r.Type != "RANGES" {
+			continue
+		}
+
+		ranges := make([]string, 0, len(r.Ranges.Range))
+		for _, rg := range r.Ranges.Range {
+			ranges = append(ranges, fmt.Sprintf("%d-%d", rg.Begin, rg.End))
+		}
+		if len(ranges) > 0 {
+			task.Resources.PortRanges = "[" + strings.Join(ranges, ", ") + "]"
+		}
+	}
+
+	return task
+}
+
+// ToSlave converts a v1 agent to its /state.json representation.
+func (a V1Agent) ToSlave() (Slave, error) {
+	s := Slave{
+		ID:       a.AgentInfo.ID.Value,
+		Hostname: a.AgentInfo.Hostname,
+	}
+
+	pid := a.PID
+	if pid == "" {
+		pid = fmt.Sprintf("slave(1)@%s:%d", a.AgentInfo.Hostname, a.AgentInfo.Port)
+	}
+
+	err := s.PID.UnmarshalJSON([]byte(pid))
+
+	return s, err
+}
@@ -51,6 +51,8 @@ type Status struct {
 	State           string          `json:"state"`
 	Labels          []Label         `json:"labels,omitempty"`
 	ContainerStatus ContainerStatus `json:"container_status,omitempty"`
+	Healthy         *bool           `json:"healthy,omitempty"`
+	Message         string          `json:"message,omitempty"`
 }
 
 // ContainerStatus holds container metadata as defined in the /state.json
@@ -85,7 +87,30 @@ type Task struct {
 	Resources     `json:"resources"`
 	DiscoveryInfo DiscoveryInfo `json:"discovery"`
 
-	SlaveIP string `json:"-"`
+	SlaveIP       string `json:"-"`
+	FrameworkName string `json:"-"`
+}
+
+// Health returns the healthy flag and the message of the latest
+// TASK_RUNNING status. healthy is nil when Mesos has no health check
+// result for the task.
+func (t *Task) Health() (healthy *bool, message string) {
+	var latest *Status
+	for i := range t.Statuses {
+		s := &t.Statuses[i]
+		if s.State != "TASK_RUNNING" {
+			continue
+		}
+		if latest == nil || s.Timestamp >= latest.Timestamp {
+			latest = s
+		}
+	}
+
+	if latest == nil {
+		return nil, ""
+	}
+
+	return latest.Healthy, latest.Message
 }
 
 // HasDiscoveryInfo return whether the DiscoveryInfo was provided in the state.json
@@ -218,6 +243,7 @@ func labels(key string) func(*Status) []string {
 
 // Framework holds a framework as defined in the /state.json Mesos HTTP endpoint.
 type Framework struct {
+	ID       string `json:"id"`
 	Tasks    []Task `json:"tasks"`
 	PID      PID    `json:"pid"`
 	Name     string `json:"name"`
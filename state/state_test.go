@@ -179,3 +179,52 @@ func netinfo(ips ...string) statusOpt {
 func timestamp(t float64) statusOpt {
 	return func(s *Status) { s.Timestamp = t }
 }
+
+func TestTask_Health(t *testing.T) {
+	for i, tt := range []struct {
+		statuses string
+		healthy  string
+		message  string
+	}{
+		{`[]`, "nil", ""},
+		{`[{"state": "TASK_RUNNING", "timestamp": 1}]`, "nil", ""},
+		{`[{"state": "TASK_RUNNING", "timestamp": 1, "healthy": true}]`, "true", ""},
+		{`[
+			{"state": "TASK_RUNNING", "timestamp": 2, "healthy": false, "message": "HTTP 500"},
+			{"state": "TASK_RUNNING", "timestamp": 1, "healthy": true},
+			{"state": "TASK_STAGING", "timestamp": 3}
+		]`, "false", "HTTP 500"},
+	} {
+		var task Task
+		if err := json.Unmarshal([]byte(`{"statuses": `+tt.statuses+`}`), &task); err != nil {
+			t.Fatal(err)
+		}
+
+		healthy, message := task.Health()
+		got := "nil"
+		if healthy != nil && *healthy {
+			got = "true"
+		} else if healthy != nil {
+			got = "false"
+		}
+		if got != tt.healthy || message != tt.message {
+			t.Errorf("test #%d: got %s %q, want %s %q", i, got, message, tt.healthy, tt.message)
+		}
+	}
+}
+
+func TestV1Task_Health(t *testing.T) {
+	var v1 V1Task
+	err := json.Unmarshal([]byte(`{"statuses": [
+		{"state": "TASK_RUNNING", "timestamp": 1, "healthy": false, "message": "HTTP 500"}
+	]}`), &v1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	task := v1.ToTask()
+	healthy, message := task.Health()
+	if healthy == nil || *healthy || message != "HTTP 500" {
+		t.Errorf("got %v %q from the operator API, want false \"HTTP 500\"", healthy, message)
+	}
+}